@@ -0,0 +1,8 @@
+package assets
+
+import _ "embed"
+
+// 战绩卡片默认使用的中文字体（文泉驿微米黑，Apache License 2.0）
+//
+//go:embed fonts/wqy-microhei.ttf
+var CardFont []byte
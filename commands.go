@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"scoringMP/config"
+	"scoringMP/service/db"
+	"scoringMP/service/importer"
+	"strings"
+	"time"
+)
+
+// 命令行子命令
+var commands = map[string]func(args []string) error{
+	"rebuild-leaderboard": func(args []string) error {
+		return db.RebuildRollups()
+	},
+	"recompute-ratings": func(args []string) error {
+		return db.RecomputeRatings()
+	},
+	"backfill-achievements": func(args []string) error {
+		return db.BackfillAchievements()
+	},
+	"import":        importSessions,
+	"migrate":       migrate,
+	"check-scores":  checkScores,
+	"rebuild-rooms": rebuildRooms,
+	"archive":       archiveRooms,
+	"backup":        backup,
+	"restore":       restore,
+}
+
+func runCommand(args []string) error {
+	command, ok := commands[args[0]]
+	if !ok {
+		return errors.New("unknown command: " + args[0])
+	}
+	err := command(args[1:])
+	if err != nil {
+		return err
+	}
+	auditCommand(args)
+	fmt.Println(args[0], "done")
+	return nil
+}
+
+// 只读或会改变审计日志表本身的命令不记录
+var unauditedCommands = map[string]bool{
+	"migrate": true,
+	"backup":  true,
+}
+
+// 记录执行成功的管理命令
+func auditCommand(args []string) {
+	if unauditedCommands[args[0]] {
+		return
+	}
+	after, err := json.Marshal(map[string]any{"args": args[1:]})
+	if err != nil {
+		slog.Error("Error encoding audit value", "err", err)
+		return
+	}
+	err = db.AppendAudit(db.AuditEntry{Actor: "cli", Action: "command." + args[0], After: after})
+	if err != nil && !errors.Is(err, db.ErrUnsupported) {
+		slog.Error("Error appending audit log", "err", err)
+	}
+}
+
+// 从 CSV 导入历史对局，如 ./main import -file sessions.csv -owner <openid> -dry-run
+func importSessions(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := flags.String("file", "", "CSV file of sessions")
+	owner := flags.String("owner", "", "openid of the imported rooms' owner")
+	gameType := flags.String("gameType", db.DefaultGameType, "game type of the imported rooms")
+	dryRun := flags.Bool("dry-run", false, "only print the report without importing")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *file == "" || *owner == "" {
+		return errors.New("-file and -owner are required")
+	}
+	_, err = db.QueryUser(*owner)
+	if err != nil {
+		return fmt.Errorf("owner %s: %w", *owner, err)
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sessions, errs := importer.Parse(f)
+	var names []string
+	for _, session := range sessions {
+		names = append(names, session.Players...)
+	}
+	users, err := db.ResolveImportNames(names)
+	if err != nil {
+		return err
+	}
+
+	// 打印导入报告
+	for _, session := range sessions {
+		fmt.Printf("session %s (%s): %d players, %d records\n", session.Key, session.Date.Format("2006-01-02 15:04"), len(session.Players), len(session.Transfers))
+		for _, name := range session.Players {
+			fmt.Printf("  %-16s %6d  %s %s\n", name, session.Results[name], users[name].Status, users[name].Openid)
+		}
+	}
+	for _, e := range errs {
+		fmt.Println("error:", e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d errors, nothing imported", len(errs))
+	}
+	if *dryRun {
+		fmt.Printf("dry run: %d sessions would be imported\n", len(sessions))
+		return nil
+	}
+	err = db.ImportSessions(*owner, *gameType, sessions, users)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d sessions imported\n", len(sessions))
+	return nil
+}
+
+// 迁移表结构，如 ./main migrate、./main migrate -to 3、./main migrate -status
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	to := flags.Int("to", -1, "target version, lower than the current version reverts migrations")
+	status := flags.Bool("status", false, "only print the migration status")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if !*status {
+		err = db.MigrateTo(*to)
+		if err != nil {
+			return err
+		}
+	}
+	states, err := db.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		applied := "pending"
+		if state.Applied {
+			applied = "applied " + state.AppliedData
+		}
+		fmt.Printf("%04d_%-20s %s\n", state.Version, state.Name, applied)
+	}
+	return nil
+}
+
+// 根据记录核对房间分数，如 ./main check-scores -repair
+func checkScores(args []string) error {
+	flags := flag.NewFlagSet("check-scores", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "rewrite drifted scores from records")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	drifts, err := db.CheckScores(*repair)
+	if err != nil {
+		return err
+	}
+	for _, d := range drifts {
+		if d.Missing {
+			fmt.Printf("room %d %s: no score row, records sum to %d\n", d.RoomId, d.Openid, d.Expected)
+		} else {
+			fmt.Printf("room %d %s: score %d, records sum to %d (drift %d)\n", d.RoomId, d.Openid, d.Score, d.Expected, d.Score-d.Expected)
+		}
+	}
+	if len(drifts) > 0 && !*repair {
+		return fmt.Errorf("%d scores drifted, run with -repair to fix", len(drifts))
+	}
+	if *repair {
+		fmt.Printf("%d scores repaired\n", len(drifts))
+	}
+	return nil
+}
+
+// 从事件日志重建房间分数，旧房间会先补写事件，如 ./main rebuild-rooms -room 12
+func rebuildRooms(args []string) error {
+	flags := flag.NewFlagSet("rebuild-rooms", flag.ContinueOnError)
+	room := flags.Int("room", 0, "only rebuild this room")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	roomIds := []int{*room}
+	if *room == 0 {
+		roomIds, err = db.QueryRoomIds()
+		if err != nil {
+			return err
+		}
+	}
+	for _, roomId := range roomIds {
+		drifts, err := db.RebuildRoom(roomId)
+		if err != nil {
+			return fmt.Errorf("room %d: %w", roomId, err)
+		}
+		for _, d := range drifts {
+			fmt.Printf("room %d %s: score %d rewritten to %d\n", d.RoomId, d.Openid, d.Score, d.Expected)
+		}
+	}
+	fmt.Printf("%d rooms rebuilt\n", len(roomIds))
+	return nil
+}
+
+// 归档关闭超过指定天数的房间，如 ./main archive -days 180
+func archiveRooms(args []string) error {
+	flags := flag.NewFlagSet("archive", flag.ContinueOnError)
+	days := flags.Int("days", config.Config.ArchiveAfterDays, "archive rooms closed more than this many days ago")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *days <= 0 {
+		return errors.New("-days must be positive")
+	}
+	archived, err := db.ArchiveRooms(time.Now().AddDate(0, 0, -*days))
+	fmt.Printf("%d rooms archived\n", len(archived))
+	return err
+}
+
+// 备份所有数据，文件名以 .gz 结尾时使用 gzip 压缩，如 ./main backup -file scoring.jsonl.gz
+func backup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	file := flags.String("file", "", "backup file, compressed when it ends with .gz")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	f, err := os.OpenFile(*file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var summary db.BackupSummary
+	if strings.HasSuffix(*file, ".gz") {
+		zw := gzip.NewWriter(f)
+		summary, err = db.Backup(zw)
+		if err == nil {
+			err = zw.Close()
+		}
+	} else {
+		summary, err = db.Backup(f)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(*file)
+		return err
+	}
+	printBackupSummary(summary)
+	return nil
+}
+
+// 从备份恢复到空库，自动识别 gzip，如 ./main restore -file scoring.jsonl.gz [-verify]
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	file := flags.String("file", "", "backup file")
+	verify := flags.Bool("verify", false, "only verify the checksums without restoring")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(2)
+	if err != nil {
+		return err
+	}
+	var summary db.BackupSummary
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		summary, err = db.Restore(zr, *verify)
+		if err != nil {
+			return err
+		}
+	} else {
+		summary, err = db.Restore(r, *verify)
+		if err != nil {
+			return err
+		}
+	}
+	printBackupSummary(summary)
+	return nil
+}
+
+func printBackupSummary(summary db.BackupSummary) {
+	fmt.Printf("backup of %s schema version %d taken at %s\n", summary.Dialect, summary.SchemaVersion, summary.CreateData)
+	for _, t := range summary.Tables {
+		fmt.Printf("  %-22s %8d rows  sha256 %s\n", t.Table, t.Rows, t.Sha256)
+	}
+}
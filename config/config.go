@@ -2,15 +2,80 @@ package config
 
 import (
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"os"
 )
 
 type IConfig struct {
-	Port      string `json:"port"`
-	Mysql     string `json:"mysql"`
-	AppId     string `json:"appId"`
-	AppSecret string `json:"appSecret"`
+	Port string `json:"port"`
+	// HTTP 服务的超时设置
+	Server IServer `json:"server"`
+	// 存储后端：mysql（默认）、sqlite、memory
+	Storage string `json:"storage"`
+	// MySQL 连接字符串，如 user:password@tcp(host:3306)/，未指定数据库时使用 database.name。
+	// 已弃用，建议改用 database
+	Mysql string `json:"mysql"`
+	// 结构化的 MySQL 配置，设置 database.host 时代替 mysql
+	Database IDatabase `json:"database"`
+	// SQLite 数据库文件路径
+	Sqlite string `json:"sqlite"`
+	// 为 true 时启动不自动迁移表结构，需先执行 ./main migrate
+	ManualMigrate bool   `json:"manualMigrate"`
+	AppId         string `json:"appId"`
+	AppSecret     string `json:"appSecret"`
+	// 结算催款订阅消息模板，模板需包含 thing1(收款人)、number2(分数)、thing3(房间) 字段，为空时只记录提醒不推送
+	RemindTemplateId string `json:"remindTemplateId"`
+	// 点击订阅消息跳转的小程序页面
+	RemindPage string `json:"remindPage"`
+	// 战绩卡片使用的中文字体文件，为空时使用内置字体
+	CardFont string `json:"cardFont"`
+	// 只读报告链接的签名密钥，为空时使用 AppSecret，两者都为空时不能生成和访问报告链接
+	ReportSecret string `json:"reportSecret"`
+	// 幂等键的有效期（分钟），期内重复请求返回首次响应
+	IdempotencyWindow int `json:"idempotencyWindow"`
+	// 房间关闭多少天后归档，为 0 时不自动归档
+	ArchiveAfterDays int `json:"archiveAfterDays"`
+	// 管理员 openid，可查询所有审计日志
+	Admins []string `json:"admins"`
+	// 日志级别：debug、info（默认）、warn、error
+	LogLevel string `json:"logLevel"`
+	// 日志格式：text（默认）或 json
+	LogFormat string `json:"logFormat"`
+}
+
+type IServer struct {
+	// 读取请求头、整个请求的超时，写响应的超时，keep-alive 空闲连接的超时（秒）
+	ReadHeaderTimeout int `json:"readHeaderTimeout"`
+	ReadTimeout       int `json:"readTimeout"`
+	WriteTimeout      int `json:"writeTimeout"`
+	IdleTimeout       int `json:"idleTimeout"`
+	// 收到 SIGTERM 后等待处理中请求及后台任务结束的最长时间（秒）
+	ShutdownTimeout int `json:"shutdownTimeout"`
+}
+
+type IDatabase struct {
+	// 主库地址，如 127.0.0.1:3306
+	Host     string `json:"host"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// 数据库名，默认 scoring，不存在时自动创建
+	Name string `json:"name"`
+	// TLS 模式：空（不加密）、true、skip-verify、preferred；设置 tlsCa 时使用该 CA 证书校验服务端
+	TLS   string `json:"tls"`
+	TLSCa string `json:"tlsCa"`
+	// 连接池：最大连接数、最大空闲连接数，连接最长存活及空闲时间（秒）
+	MaxOpenConns    int `json:"maxOpenConns"`
+	MaxIdleConns    int `json:"maxIdleConns"`
+	ConnMaxLifetime int `json:"connMaxLifetime"`
+	ConnMaxIdleTime int `json:"connMaxIdleTime"`
+	// 建立连接及单次读、写的超时时间（秒），读超时同时限制了查询的最长等待时间
+	DialTimeout  int `json:"dialTimeout"`
+	ReadTimeout  int `json:"readTimeout"`
+	WriteTimeout int `json:"writeTimeout"`
+	// 启动时连接数据库失败的重试时间（秒），期间按指数退避重试，为 0 时使用默认值 60
+	ConnectRetryTimeout int `json:"connectRetryTimeout"`
+	// 只读从库的连接字符串（需包含数据库名），历史、统计和房间详情查询优先发往从库，出错时回退到主库
+	Replicas []string `json:"replicas"`
 }
 
 var Config IConfig
@@ -19,15 +84,65 @@ func InitConfig() error {
 	// 读取 config.json 文件
 	file, err := os.Open("config.json")
 	if err != nil {
-		fmt.Println("Error opening config file:", err)
+		slog.Error("Error opening config file", "err", err)
 		return err
 	}
 	defer file.Close()
 
 	err = json.NewDecoder(file).Decode(&Config)
 	if err != nil {
-		fmt.Println("Error decoding config file:", err)
+		slog.Error("Error decoding config file", "err", err)
 		return err
 	}
+	if Config.Sqlite == "" {
+		Config.Sqlite = "scoring.db"
+	}
+	if Config.Database.Name == "" {
+		Config.Database.Name = "scoring"
+	}
+	if Config.Database.MaxOpenConns <= 0 {
+		Config.Database.MaxOpenConns = 20
+	}
+	if Config.Database.MaxIdleConns <= 0 {
+		Config.Database.MaxIdleConns = 10
+	}
+	// 小于 MySQL 默认的 wait_timeout，避免使用已被服务端断开的连接
+	if Config.Database.ConnMaxLifetime <= 0 {
+		Config.Database.ConnMaxLifetime = 300
+	}
+	if Config.Database.ConnMaxIdleTime <= 0 {
+		Config.Database.ConnMaxIdleTime = 60
+	}
+	if Config.Database.DialTimeout <= 0 {
+		Config.Database.DialTimeout = 5
+	}
+	if Config.Database.ReadTimeout <= 0 {
+		Config.Database.ReadTimeout = 30
+	}
+	if Config.Database.WriteTimeout <= 0 {
+		Config.Database.WriteTimeout = 30
+	}
+	if Config.Database.ConnectRetryTimeout <= 0 {
+		Config.Database.ConnectRetryTimeout = 60
+	}
+	if Config.Server.ReadHeaderTimeout <= 0 {
+		Config.Server.ReadHeaderTimeout = 10
+	}
+	if Config.Server.ReadTimeout <= 0 {
+		Config.Server.ReadTimeout = 30
+	}
+	// 导出表格、生成战绩卡片较慢，写超时留有余量
+	if Config.Server.WriteTimeout <= 0 {
+		Config.Server.WriteTimeout = 60
+	}
+	if Config.Server.IdleTimeout <= 0 {
+		Config.Server.IdleTimeout = 120
+	}
+	if Config.Server.ShutdownTimeout <= 0 {
+		Config.Server.ShutdownTimeout = 30
+	}
+	if Config.IdempotencyWindow <= 0 {
+		Config.IdempotencyWindow = 24 * 60
+	}
 	return nil
 }
@@ -0,0 +1,23 @@
+package handles
+
+import (
+	"database/sql"
+	"scoringMP/service/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取用户资料及徽章，不传 openid 时查询自己
+func GetProfile(c *gin.Context) {
+	openId := c.DefaultQuery("openid", c.Request.Header.Get("openId"))
+	profile, err := db.GetProfile(openId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(400, gin.H{"error": "user is not exist"})
+			return
+		}
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, profile)
+}
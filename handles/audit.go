@@ -0,0 +1,138 @@
+package handles
+
+import (
+	"encoding/json"
+	"errors"
+	"scoringMP/config"
+	"scoringMP/logger"
+	"scoringMP/middleware"
+	"scoringMP/service/db"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 设置本次请求的审计详情，请求成功后由 middleware.Audit 写入审计日志。
+// before、after 为修改前后的值，nil 表示没有
+func audit(c *gin.Context, action string, roomId int, targetUser string, before any, after any) {
+	auditAs(c, "", action, roomId, targetUser, before, after)
+}
+
+// 同 audit，指定操作人，用于登录等请求头中没有 openId 的请求
+func auditAs(c *gin.Context, actor string, action string, roomId int, targetUser string, before any, after any) {
+	entry := db.AuditEntry{Actor: actor, Action: action, RoomId: roomId, TargetUser: targetUser}
+	var err error
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+		if err != nil {
+			logger.FromContext(c.Request.Context()).Error("Error encoding audit value", "err", err)
+		}
+	}
+	if after != nil {
+		entry.After, err = json.Marshal(after)
+		if err != nil {
+			logger.FromContext(c.Request.Context()).Error("Error encoding audit value", "err", err)
+		}
+	}
+	c.Set(middleware.AuditKey, entry)
+}
+
+// 审计日志中记录的房间内玩家分数
+func roomScores(roomId int, openids ...string) map[string]int {
+	scores, err := db.GetRoomScores(roomId)
+	if err != nil {
+		return nil
+	}
+	result := make(map[string]int, len(openids))
+	for _, s := range scores {
+		if slices.Contains(openids, s.Openid) {
+			result[s.Openid] = s.Score
+		}
+	}
+	return result
+}
+
+// 解析审计日志的翻页参数
+func auditPage(c *gin.Context, filter *db.AuditFilter) error {
+	var err error
+	if c.Query("beforeId") != "" {
+		filter.BeforeId, err = strconv.Atoi(c.Query("beforeId"))
+		if err != nil {
+			return errors.New("beforeId must be an integer")
+		}
+	}
+	if c.Query("limit") != "" {
+		filter.Limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil {
+			return errors.New("limit must be an integer")
+		}
+	}
+	return nil
+}
+
+// 房主查看房间的审计日志
+func GetRoomAudit(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	roomId, err := strconv.Atoi(c.Query("roomId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "roomId is required"})
+		return
+	}
+	room, err := db.QueryRoom(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if room.Owner != openId {
+		c.JSON(403, gin.H{"error": "user is not room owner"})
+		return
+	}
+	filter := db.AuditFilter{RoomId: roomId}
+	err = auditPage(c, &filter)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	entries, err := db.QueryAudit(filter)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, entries)
+}
+
+// 管理员按操作人、操作、房间、用户及时间范围查询审计日志
+func GetAuditLog(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	if openId == "" || !slices.Contains(config.Config.Admins, openId) {
+		c.JSON(403, gin.H{"error": "user is not admin"})
+		return
+	}
+	filter := db.AuditFilter{
+		Actor:  c.Query("actor"),
+		Action: c.Query("action"),
+		User:   c.Query("user"),
+		From:   c.Query("from"),
+		To:     c.Query("to"),
+	}
+	var err error
+	if c.Query("roomId") != "" {
+		filter.RoomId, err = strconv.Atoi(c.Query("roomId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "roomId must be an integer"})
+			return
+		}
+	}
+	err = auditPage(c, &filter)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	entries, err := db.QueryAudit(filter)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, entries)
+}
@@ -0,0 +1,66 @@
+package handles
+
+import (
+	"database/sql"
+	"fmt"
+	"scoringMP/config"
+	"scoringMP/service/card"
+	"scoringMP/service/db"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取房间战绩分享卡片
+func GetRoomCard(c *gin.Context) {
+	roomId, err := strconv.Atoi(c.Query("roomId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "roomId is required"})
+		return
+	}
+	room, err := db.QueryRoom(roomId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(400, gin.H{"error": "room is not exist"})
+			return
+		}
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	users, err := db.GetRoomUsers(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	sort.SliceStable(users, func(i, j int) bool { return users[i].Score > users[j].Score })
+	data := card.Data{Title: fmt.Sprintf("房间 %d", room.Id), Date: room.CreateData}
+	if len(room.CreateData) >= 10 {
+		data.Date = room.CreateData[:10]
+	}
+	for _, user := range users {
+		data.Players = append(data.Players, card.Player{Nickname: user.Nickname, Score: user.Score})
+	}
+	biggest, err := db.GetBiggestRecord(roomId)
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err == nil {
+		data.BiggestHand = fmt.Sprintf("%s 赢 %s %d 分", biggest.ToUser, biggest.FromUser, biggest.Score)
+	}
+	// 内容未变化时客户端可直接使用缓存
+	etag := `"` + data.Version() + `"`
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(304)
+		return
+	}
+	b, err := card.Get(config.Config.CardFont, roomId, data)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	c.Data(200, "image/png", b)
+}
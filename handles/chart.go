@@ -0,0 +1,128 @@
+package handles
+
+import (
+	"scoringMP/service/chart"
+	"scoringMP/service/db"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 默认最多返回的点数
+const defaultMaxPoints = 200
+
+// 解析 maxPoints 参数
+func parseMaxPoints(c *gin.Context) (int, bool) {
+	maxPoints, err := strconv.Atoi(c.DefaultQuery("maxPoints", strconv.Itoa(defaultMaxPoints)))
+	if err != nil || maxPoints < 2 || maxPoints > 1000 {
+		c.JSON(400, gin.H{"error": "maxPoints must be between 2 and 1000"})
+		return 0, false
+	}
+	return maxPoints, true
+}
+
+// 解析日期参数，返回当天起止时间
+func parseDate(c *gin.Context, key string, end bool) (string, bool) {
+	value := c.Query(key)
+	if value == "" {
+		return "", true
+	}
+	if _, err := time.Parse("2006-01-02", value); err != nil {
+		c.JSON(400, gin.H{"error": key + " must be yyyy-mm-dd"})
+		return "", false
+	}
+	if end {
+		return value + " 23:59:59", true
+	}
+	return value + " 00:00:00", true
+}
+
+// 获取用户累计得分曲线
+func GetChart(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	bucket := c.DefaultQuery("bucket", chart.BucketSession)
+	maxPoints, ok := parseMaxPoints(c)
+	if !ok {
+		return
+	}
+	from, ok := parseDate(c, "from", false)
+	if !ok {
+		return
+	}
+	to, ok := parseDate(c, "to", true)
+	if !ok {
+		return
+	}
+	var deltas []chart.Delta
+	var err error
+	switch bucket {
+	case chart.BucketSession:
+		deltas, err = db.GetPlayerSessionDeltas(openId)
+	case chart.BucketRecord, chart.BucketDay, chart.BucketWeek:
+		deltas, err = db.GetPlayerRecordDeltas(openId)
+	default:
+		c.JSON(400, gin.H{"error": "invalid bucket"})
+		return
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	points, err := chart.Cumulative(deltas, bucket, from, to)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"bucket": bucket, "total": len(points), "points": chart.Downsample(points, maxPoints)})
+}
+
+type RoomSeries struct {
+	Openid   string `json:"openid"`
+	Nickname string `json:"nickname"`
+	Values   []int  `json:"values"`
+}
+
+// 获取房间内每位玩家随记录累积的得分曲线
+func GetRoomChart(c *gin.Context) {
+	roomId, err := strconv.Atoi(c.Query("roomId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "roomId is required"})
+		return
+	}
+	maxPoints, ok := parseMaxPoints(c)
+	if !ok {
+		return
+	}
+	users, err := db.GetRoomUsers(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	records, err := db.GetRoomRecordRows(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	indexes := chart.SampleIndexes(len(records), maxPoints)
+	times := make([]string, 0, len(indexes))
+	series := make([]RoomSeries, len(users))
+	current := make(map[string]int)
+	for i, user := range users {
+		series[i] = RoomSeries{Openid: user.Openid, Nickname: user.Nickname, Values: make([]int, 0, len(indexes))}
+	}
+	next := 0
+	for i, record := range records {
+		current[record.FromUser] -= record.Score
+		current[record.ToUser] += record.Score
+		if next >= len(indexes) || indexes[next] != i {
+			continue
+		}
+		next++
+		times = append(times, record.CreateData)
+		for j := range series {
+			series[j].Values = append(series[j].Values, current[series[j].Openid])
+		}
+	}
+	c.JSON(200, gin.H{"total": len(records), "times": times, "series": series})
+}
@@ -0,0 +1,120 @@
+package handles
+
+import (
+	"scoringMP/service/db"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 房间成员查看事件日志，seq 指定时同时返回该时刻的房间状态
+func GetRoomEvents(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	roomId, err := strconv.Atoi(c.Query("roomId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "roomId is required"})
+		return
+	}
+	seq := 0
+	if c.Query("seq") != "" {
+		seq, err = strconv.Atoi(c.Query("seq"))
+		if err != nil || seq < 0 {
+			c.JSON(400, gin.H{"error": "seq must be a non-negative integer"})
+			return
+		}
+	}
+	users, err := db.GetRoomUsers(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	member := false
+	for _, user := range users {
+		if user.Openid == openId {
+			member = true
+		}
+	}
+	if !member {
+		c.JSON(403, gin.H{"error": "user is not in room"})
+		return
+	}
+	events, err := db.GetRoomEvents(roomId, 0)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	state, err := db.GetRoomState(roomId, seq)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"events": events, "state": state})
+}
+
+type VoidRecordModel struct {
+	RoomId   int `json:"roomId"`
+	RecordId int `json:"recordId"`
+}
+
+// 房主作废一条记录
+func VoidRecord(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	var data VoidRecordModel
+	err := c.Bind(&data)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	var before any
+	records, err := db.GetRoomRecordRows(data.RoomId)
+	if err == nil {
+		for _, record := range records {
+			if record.Id == data.RecordId {
+				before = record
+			}
+		}
+	}
+	err = db.VoidRecord(openId, data.RoomId, data.RecordId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	audit(c, "record.void", data.RoomId, "", before, gin.H{"voided": data.RecordId})
+	c.String(200, "ok")
+}
+
+type RoomSettingsModel struct {
+	RoomId   int    `json:"roomId"`
+	GameType string `json:"gameType"`
+}
+
+// 房主修改房间设置
+func UpdateRoomSettings(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	var data RoomSettingsModel
+	err := c.Bind(&data)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if data.GameType == "" {
+		c.JSON(400, gin.H{"error": "gameType is required"})
+		return
+	}
+	if len(data.GameType) > 64 {
+		c.JSON(400, gin.H{"error": "gameType is too long"})
+		return
+	}
+	gameType, err := db.GetRoomGameType(data.RoomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	err = db.UpdateRoomSettings(openId, data.RoomId, data.GameType)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	audit(c, "room.settings", data.RoomId, "", gin.H{"gameType": gameType}, gin.H{"gameType": data.GameType})
+	c.String(200, "ok")
+}
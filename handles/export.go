@@ -0,0 +1,185 @@
+package handles
+
+import (
+	"database/sql"
+	"fmt"
+	"scoringMP/logger"
+	"scoringMP/service/db"
+	"scoringMP/service/export"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 房间总分、记录和逐局明细
+type scoresheet struct {
+	Users   []db.UserScore
+	Records []db.UserRecord
+	// 每条记录视为一局，行为局、列为玩家
+	Grid [][]int
+}
+
+// 读取房间计分表，玩家按得分从高到低、记录按时间先后排列
+func loadScoresheet(roomId int) (scoresheet, error) {
+	var sheet scoresheet
+	var err error
+	sheet.Users, err = db.GetRoomUsers(roomId)
+	if err != nil {
+		return sheet, err
+	}
+	sheet.Records, err = db.GetRoomRecords(roomId)
+	if err != nil {
+		return sheet, err
+	}
+	for i, j := 0, len(sheet.Records)-1; i < j; i, j = i+1, j-1 {
+		sheet.Records[i], sheet.Records[j] = sheet.Records[j], sheet.Records[i]
+	}
+	rows, err := db.GetRoomRecordRows(roomId)
+	if err != nil {
+		return sheet, err
+	}
+	// 记录中不在玩家列表里的人（分数行已被删除）单独成列，得分由记录汇总
+	known := make(map[string]bool)
+	for _, user := range sheet.Users {
+		known[user.Openid] = true
+	}
+	missing := make(map[string]int)
+	var missingOrder []string
+	for _, record := range rows {
+		for _, side := range []struct {
+			openid string
+			delta  int
+		}{{record.FromUser, -record.Score}, {record.ToUser, record.Score}} {
+			if known[side.openid] {
+				continue
+			}
+			if _, ok := missing[side.openid]; !ok {
+				missingOrder = append(missingOrder, side.openid)
+			}
+			missing[side.openid] += side.delta
+		}
+	}
+	for _, openid := range missingOrder {
+		user, err := db.QueryUser(openid)
+		if err != nil {
+			return sheet, err
+		}
+		sheet.Users = append(sheet.Users, db.UserScore{Openid: openid, Nickname: user.Nickname, Score: missing[openid]})
+	}
+	sort.SliceStable(sheet.Users, func(i, j int) bool { return sheet.Users[i].Score > sheet.Users[j].Score })
+	column := make(map[string]int)
+	for i, user := range sheet.Users {
+		column[user.Openid] = i
+	}
+	for _, record := range rows {
+		round := make([]int, len(sheet.Users))
+		from, fromOk := column[record.FromUser]
+		to, toOk := column[record.ToUser]
+		if !fromOk || !toOk {
+			return sheet, fmt.Errorf("record %d refers to unknown player", record.Id)
+		}
+		round[from] -= record.Score
+		round[to] += record.Score
+		sheet.Grid = append(sheet.Grid, round)
+	}
+	return sheet, nil
+}
+
+// 转换为导出用的工作表
+func (s scoresheet) sheets() []export.Sheet {
+	totals := export.Sheet{Name: "总分", Rows: [][]string{{"排名", "昵称", "得分"}}}
+	for i, user := range s.Users {
+		totals.Rows = append(totals.Rows, []string{strconv.Itoa(i + 1), user.Nickname, strconv.Itoa(user.Score)})
+	}
+	records := export.Sheet{Name: "记录", Rows: [][]string{{"时间", "付分", "得分", "分数"}}}
+	for _, record := range s.Records {
+		records.Rows = append(records.Rows, []string{record.Time, record.FromUser, record.ToUser, strconv.Itoa(record.Score)})
+	}
+	sheets := []export.Sheet{totals, records}
+	if len(s.Grid) == 0 {
+		return sheets
+	}
+	header := []string{"局"}
+	for _, user := range s.Users {
+		header = append(header, user.Nickname)
+	}
+	grid := export.Sheet{Name: "逐局", Rows: [][]string{header}}
+	for i, round := range s.Grid {
+		row := []string{strconv.Itoa(i + 1)}
+		for _, delta := range round {
+			if delta == 0 {
+				row = append(row, "")
+			} else {
+				row = append(row, strconv.Itoa(delta))
+			}
+		}
+		grid.Rows = append(grid.Rows, row)
+	}
+	total := []string{"合计"}
+	for _, user := range s.Users {
+		total = append(total, strconv.Itoa(user.Score))
+	}
+	grid.Rows = append(grid.Rows, total)
+	return append(sheets, grid)
+}
+
+// csv 导出时 sheet 参数对应的工作表
+var csvSheets = map[string]int{
+	"totals":  0,
+	"records": 1,
+	"grid":    2,
+}
+
+// 房主导出房间计分表，csv 每次导出一个工作表，xlsx 包含所有工作表
+func ExportRoom(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	roomId, err := strconv.Atoi(c.Query("roomId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "roomId is required"})
+		return
+	}
+	room, err := db.QueryRoom(roomId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(400, gin.H{"error": "room is not exist"})
+			return
+		}
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if room.Owner != openId {
+		c.JSON(403, gin.H{"error": "only owner can export room"})
+		return
+	}
+	data, err := loadScoresheet(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	sheets := data.sheets()
+	switch c.DefaultQuery("format", "xlsx") {
+	case "csv":
+		name := c.DefaultQuery("sheet", "totals")
+		index, ok := csvSheets[name]
+		if !ok || index >= len(sheets) {
+			c.JSON(400, gin.H{"error": "sheet must be totals, records or grid"})
+			return
+		}
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="room-%d-%s.csv"`, roomId, name))
+		err = export.WriteCSV(c.Writer, sheets[index])
+		if err != nil {
+			logger.FromContext(c.Request.Context()).Error("Error writing csv", "err", err)
+		}
+	case "xlsx":
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="room-%d.xlsx"`, roomId))
+		err = export.WriteXLSX(c.Writer, sheets)
+		if err != nil {
+			logger.FromContext(c.Request.Context()).Error("Error writing xlsx", "err", err)
+		}
+	default:
+		c.JSON(400, gin.H{"error": "format must be csv or xlsx"})
+	}
+}
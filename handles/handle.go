@@ -3,6 +3,7 @@ package handles
 import (
 	"database/sql"
 	"fmt"
+	"io"
 	"scoringMP/service/db"
 	"scoringMP/service/mp"
 	"strconv"
@@ -27,7 +28,7 @@ func Login(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "code is required"})
 		return
 	}
-	openId, err := mp.Code2Session(code)
+	openId, err := mp.Code2Session(c.Request.Context(), code)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 	}
@@ -36,17 +37,20 @@ func Login(c *gin.Context) {
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// 注册，昵称为用户openId前6位
-			err = db.RegisterUser(openId, "用户"+openId[:6])
+			nickname := "用户" + openId[:6]
+			err = db.RegisterUser(openId, nickname)
 			if err != nil {
 				c.JSON(400, gin.H{"error": err.Error()})
 				return
 			}
+			auditAs(c, openId, "user.register", 0, openId, nil, gin.H{"nickname": nickname})
 			c.JSON(200, gin.H{"openId": openId, "roomId": nil})
 			return
 		}
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	auditAs(c, openId, "user.login", 0, openId, nil, nil)
 	// 查询用户房间
 	room, err := db.QueryUserRoom(openId)
 	if err != nil {
@@ -108,16 +112,36 @@ func JoinRoom(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	audit(c, "room.join", data.RoomId, openId, nil, nil)
+}
+
+type CreateRoomModel struct {
+	GameType string `json:"gameType"`
 }
 
 // 创建/回到房间
 func CreateRoom(c *gin.Context) {
 	openId := c.Request.Header.Get("openId")
-	roomId, err := db.CreateRoom(openId)
+	var data CreateRoomModel
+	// 兼容不带 body 的旧版请求
+	err := c.ShouldBindJSON(&data)
+	if err != nil && err != io.EOF {
+		c.JSON(400, gin.H{"error": "body error"})
+		return
+	}
+	if data.GameType == "" {
+		data.GameType = db.DefaultGameType
+	}
+	if len(data.GameType) > 64 {
+		c.JSON(400, gin.H{"error": "gameType is too long"})
+		return
+	}
+	roomId, err := db.CreateRoom(openId, data.GameType)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	audit(c, "room.create", roomId, "", nil, gin.H{"gameType": data.GameType})
 	c.String(200, fmt.Sprint(roomId))
 }
 
@@ -191,11 +215,18 @@ func AddRecord(c *gin.Context) {
 		return
 	}
 	// 插入记录
+	before := roomScores(data.RoomId, data.FromUser, data.ToUser)
 	err = db.AddRecord(data.RoomId, data.FromUser, data.ToUser, data.Score)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	audit(c, "record.add", data.RoomId, data.ToUser, gin.H{"scores": before}, gin.H{
+		"fromUser": data.FromUser,
+		"toUser":   data.ToUser,
+		"score":    data.Score,
+		"scores":   roomScores(data.RoomId, data.FromUser, data.ToUser),
+	})
 	c.String(200, "ok")
 }
 
@@ -212,11 +243,17 @@ func UpdateNickname(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "body error"})
 		return
 	}
+	user, err := db.QueryUser(openId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 	err = db.UpdateNickname(openId, data.Nickname)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	audit(c, "user.nickname", 0, openId, gin.H{"nickname": user.Nickname}, gin.H{"nickname": data.Nickname})
 	c.String(200, "ok")
 }
 
@@ -233,9 +270,14 @@ func ExitRoom(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "body error"})
 		return
 	}
-	_, err = db.QuitRoom(openId, data.RoomId)
+	closed, err := db.QuitRoom(openId, data.RoomId)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if closed {
+		audit(c, "room.close", data.RoomId, "", nil, nil)
+	} else {
+		audit(c, "room.leave", data.RoomId, openId, nil, nil)
+	}
 }
@@ -0,0 +1,67 @@
+package handles
+
+import (
+	"context"
+	"errors"
+	"scoringMP/logger"
+	"scoringMP/service/db"
+	"scoringMP/service/mp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 就绪检查中单项检查的结果
+type healthCheck struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	ExpireAt string `json:"expireAt,omitempty"`
+}
+
+func checkResult(err error) healthCheck {
+	switch {
+	case err == nil:
+		return healthCheck{Status: "ok"}
+	case errors.Is(err, db.ErrUnsupported):
+		// 内存存储没有数据库连接和迁移
+		return healthCheck{Status: "skipped"}
+	}
+	return healthCheck{Status: "failed", Error: err.Error()}
+}
+
+// 存活检查，进程能处理请求即返回 200
+func Healthz(c *gin.Context) {
+	c.JSON(200, gin.H{"status": "ok"})
+}
+
+// 就绪检查：数据库可连接且已迁移到最新版本时返回 200，否则返回 503。
+// access_token 只在发送订阅消息时按需获取，其状态仅供参考，不影响就绪
+func Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+	checks := map[string]healthCheck{}
+	checks["database"] = checkResult(db.Ping(ctx))
+	if checks["database"].Status != "ok" {
+		checks["migrations"] = healthCheck{Status: "skipped"}
+	} else {
+		checks["migrations"] = checkResult(db.CheckSchemaVersion(ctx))
+	}
+	state, expireAt := mp.TokenState()
+	token := healthCheck{Status: state}
+	if !expireAt.IsZero() {
+		token.ExpireAt = expireAt.Format(time.RFC3339)
+	}
+	checks["accessToken"] = token
+
+	status, code := "ready", 200
+	for _, name := range []string{"database", "migrations"} {
+		if checks[name].Status == "failed" {
+			status, code = "not ready", 503
+		}
+	}
+	if code != 200 {
+		// 健康检查不写访问日志，未就绪时单独记录
+		logger.FromContext(c.Request.Context()).Warn("Not ready", "checks", checks)
+	}
+	c.JSON(code, gin.H{"status": status, "checks": checks})
+}
@@ -0,0 +1,29 @@
+package handles
+
+import (
+	"scoringMP/service/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取好友圈排行榜
+func GetLeaderboard(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	period := c.DefaultQuery("period", db.PeriodWeek)
+	sortBy := c.DefaultQuery("sort", db.SortNet)
+	periodKey := c.Query("periodKey")
+	if periodKey == "" {
+		var err error
+		periodKey, err = db.CurrentPeriodKey(period)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	items, err := db.GetLeaderboard(openId, period, periodKey, sortBy)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"period": period, "periodKey": periodKey, "items": items})
+}
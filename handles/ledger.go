@@ -0,0 +1,114 @@
+package handles
+
+import (
+	"scoringMP/model"
+	"scoringMP/service/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取用户与其他玩家的累计欠款及待确认还款
+func GetLedger(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	balances, err := db.GetLedgerBalances(openId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	payments, err := db.GetPendingPayments(openId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"balances": balances, "pendingPayments": payments})
+}
+
+type CreatePaymentModel struct {
+	Payer  string `json:"payer"`
+	Payee  string `json:"payee"`
+	Points int    `json:"points"`
+}
+
+// 登记线下还款
+func CreatePayment(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	var data CreatePaymentModel
+	err := c.Bind(&data)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "body error"})
+		return
+	}
+	id, err := db.CreatePayment(openId, data.Payer, data.Payee, data.Points)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	target := data.Payee
+	if target == openId {
+		target = data.Payer
+	}
+	audit(c, "payment.create", 0, target, nil, gin.H{"id": id, "payer": data.Payer, "payee": data.Payee, "points": data.Points})
+	c.JSON(200, gin.H{"id": id})
+}
+
+type PaymentModel struct {
+	Id int `json:"id"`
+}
+
+// 确认还款
+func ConfirmPayment(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	var data PaymentModel
+	err := c.Bind(&data)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "body error"})
+		return
+	}
+	before, err := db.GetPayment(data.Id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	err = db.ConfirmPayment(openId, data.Id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	after, err := db.GetPayment(data.Id)
+	if err == nil {
+		auditPayment(c, "payment.confirm", openId, before, after)
+	}
+	c.String(200, "ok")
+}
+
+// 拒绝或撤销还款
+func CancelPayment(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	var data PaymentModel
+	err := c.Bind(&data)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "body error"})
+		return
+	}
+	before, err := db.GetPayment(data.Id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	err = db.CancelPayment(openId, data.Id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	auditPayment(c, "payment.cancel", openId, before, nil)
+	c.String(200, "ok")
+}
+
+// 记录还款的审计日志，对象为另一方
+func auditPayment(c *gin.Context, action string, openId string, before model.LedgerPayment, after any) {
+	target := before.Payee
+	if target == openId {
+		target = before.Payer
+	}
+	audit(c, action, 0, target, before, after)
+}
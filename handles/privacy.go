@@ -0,0 +1,71 @@
+package handles
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"scoringMP/logger"
+	"scoringMP/service/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 导出用户个人数据，format 为 json 或 zip（每张表一个 json 文件）
+func ExportUserData(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	data, err := db.ExportUserData(openId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(400, gin.H{"error": "user is not exist"})
+			return
+		}
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.Header("Content-Disposition", `attachment; filename="scoring-data.json"`)
+		c.JSON(200, data)
+	case "zip":
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", `attachment; filename="scoring-data.zip"`)
+		zw := zip.NewWriter(c.Writer)
+		for table, rows := range data {
+			f, err := zw.Create(table + ".json")
+			if err != nil {
+				logger.FromContext(c.Request.Context()).Error("Error writing zip", "err", err)
+				return
+			}
+			encoder := json.NewEncoder(f)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(rows)
+			if err != nil {
+				logger.FromContext(c.Request.Context()).Error("Error writing zip", "err", err)
+				return
+			}
+		}
+		err = zw.Close()
+		if err != nil {
+			logger.FromContext(c.Request.Context()).Error("Error writing zip", "err", err)
+		}
+	default:
+		c.JSON(400, gin.H{"error": "format must be json or zip"})
+	}
+}
+
+// 注销账号，匿名化其他玩家仍可见的数据
+func DeleteUser(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	placeholder, err := db.DeleteUser(openId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(400, gin.H{"error": "user is not exist"})
+			return
+		}
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	// 原 openid 已匿名化，日志中记录占位用户
+	auditAs(c, placeholder, "user.delete", 0, placeholder, nil, nil)
+	c.String(200, "ok")
+}
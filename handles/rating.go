@@ -0,0 +1,46 @@
+package handles
+
+import (
+	"scoringMP/service/db"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 获取用户各玩法等级分
+func GetRatings(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	ratings, err := db.GetRatings(openId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, ratings)
+}
+
+// 获取用户等级分历史
+func GetRatingHistory(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	gameType := c.DefaultQuery("gameType", db.DefaultGameType)
+	history, err := db.GetRatingHistory(openId, gameType)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, history)
+}
+
+// 获取房间内每位玩家的等级分变化
+func GetRoomRating(c *gin.Context) {
+	roomId, err := strconv.Atoi(c.Query("roomId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "roomId is required"})
+		return
+	}
+	changes, err := db.GetRoomRatingChanges(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, changes)
+}
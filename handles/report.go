@@ -0,0 +1,140 @@
+package handles
+
+import (
+	"bytes"
+	"database/sql"
+	"scoringMP/service/db"
+	"scoringMP/service/report"
+	"scoringMP/service/settle"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 报告链接有效期
+const reportTTL = 7 * 24 * time.Hour
+
+// 房间成员获取只读报告链接
+func GetReportLink(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	roomId, err := strconv.Atoi(c.Query("roomId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "roomId is required"})
+		return
+	}
+	users, err := db.GetRoomUsers(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	member := false
+	for _, user := range users {
+		if user.Openid == openId {
+			member = true
+		}
+	}
+	if !member {
+		c.JSON(403, gin.H{"error": "user is not in room"})
+		return
+	}
+	query, err := report.Sign(roomId, reportTTL)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"url": "/report?" + query})
+}
+
+// 可打印的房间报告页面，通过签名链接访问
+func GetReport(c *gin.Context) {
+	roomId, err := strconv.Atoi(c.Query("roomId"))
+	if err != nil {
+		c.String(400, "roomId is required")
+		return
+	}
+	exp, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+	if err != nil {
+		c.String(403, report.ErrInvalid.Error())
+		return
+	}
+	err = report.Verify(roomId, exp, c.Query("sig"))
+	if err == report.ErrNoSecret {
+		c.String(500, err.Error())
+		return
+	}
+	if err != nil {
+		c.String(403, err.Error())
+		return
+	}
+	room, err := db.QueryRoom(roomId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.String(404, "room is not exist")
+			return
+		}
+		c.String(500, err.Error())
+		return
+	}
+	owner, err := db.QueryUser(room.Owner)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	gameType, err := db.GetRoomGameType(roomId)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	sheet, err := loadScoresheet(roomId)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	data := report.Data{
+		RoomId:     roomId,
+		Owner:      owner.Nickname,
+		GameType:   gameType,
+		CreateData: room.CreateData,
+		Opened:     room.Opened,
+		Grid:       sheet.Grid,
+		Generated:  time.Now().Format("2006-01-02 15:04:05"),
+	}
+	nicknames := make(map[string]string)
+	balances := make([]settle.Balance, 0, len(sheet.Users))
+	for _, user := range sheet.Users {
+		nicknames[user.Openid] = user.Nickname
+		balances = append(balances, settle.Balance{Openid: user.Openid, Points: user.Score})
+		data.Players = append(data.Players, report.Player{Nickname: user.Nickname, Score: user.Score})
+	}
+	for _, record := range sheet.Records {
+		data.Records = append(data.Records, report.Record{Time: record.Time, FromUser: record.FromUser, ToUser: record.ToUser, Score: record.Score})
+	}
+	// 已关闭的房间使用保存的结算及付款状态，进行中的房间按当前得分计算
+	if !room.Opened {
+		settlements, err := db.GetRoomSettlements(roomId)
+		if err != nil {
+			c.String(500, err.Error())
+			return
+		}
+		for _, s := range settlements {
+			data.Transfers = append(data.Transfers, report.Transfer{FromUser: s.FromNickname, ToUser: s.ToNickname, Points: s.Points, Status: s.Status})
+		}
+	} else {
+		transfers, err := settle.Minimize(balances)
+		if err != nil {
+			c.String(500, err.Error())
+			return
+		}
+		for _, t := range transfers {
+			data.Transfers = append(data.Transfers, report.Transfer{FromUser: nicknames[t.From], ToUser: nicknames[t.To], Points: t.Points})
+		}
+	}
+	var buf bytes.Buffer
+	err = report.Render(&buf, data)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.Data(200, "text/html; charset=utf-8", buf.Bytes())
+}
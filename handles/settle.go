@@ -0,0 +1,207 @@
+package handles
+
+import (
+	"fmt"
+	"scoringMP/config"
+	"scoringMP/logger"
+	"scoringMP/service/db"
+	"scoringMP/service/mp"
+	"scoringMP/service/settle"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SettlementItem struct {
+	From         string   `json:"from"`
+	FromNickname string   `json:"fromNickname"`
+	To           string   `json:"to"`
+	ToNickname   string   `json:"toNickname"`
+	Points       int      `json:"points"`
+	Amount       *float64 `json:"amount,omitempty"`
+}
+
+// 计算房间结算所需的最少转账
+func GetSettlement(c *gin.Context) {
+	roomId, err := strconv.Atoi(c.Query("roomId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "roomId is required"})
+		return
+	}
+	var rate float64
+	if c.Query("rate") != "" {
+		rate, err = strconv.ParseFloat(c.Query("rate"), 64)
+		if err != nil || rate <= 0 {
+			c.JSON(400, gin.H{"error": "rate must be a positive number"})
+			return
+		}
+	}
+	round := c.DefaultQuery("round", "fen")
+	unit, ok := settle.RoundUnits[round]
+	if !ok {
+		c.JSON(400, gin.H{"error": "round must be fen, jiao or yuan"})
+		return
+	}
+	users, err := db.GetRoomUsers(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	nicknames := make(map[string]string)
+	balances := make([]settle.Balance, 0, len(users))
+	for _, user := range users {
+		nicknames[user.Openid] = user.Nickname
+		balances = append(balances, settle.Balance{Openid: user.Openid, Points: user.Score})
+	}
+	transfers, err := settle.Minimize(balances)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]SettlementItem, 0, len(transfers))
+	for _, t := range transfers {
+		item := SettlementItem{
+			From:         t.From,
+			FromNickname: nicknames[t.From],
+			To:           t.To,
+			ToNickname:   nicknames[t.To],
+			Points:       t.Points,
+		}
+		if rate > 0 {
+			amount := settle.Amount(t.Points, rate, unit)
+			item.Amount = &amount
+		}
+		items = append(items, item)
+	}
+	c.JSON(200, gin.H{"roomId": roomId, "rate": rate, "round": round, "transfers": items})
+}
+
+// 获取房间已保存的结算转账及是否全部付清
+func GetSettlementHistory(c *gin.Context) {
+	roomId, err := strconv.Atoi(c.Query("roomId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "roomId is required"})
+		return
+	}
+	opened, err := db.CheckRoom(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	transfers, err := db.GetRoomSettlements(roomId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	cleared := !opened
+	for _, t := range transfers {
+		if t.Status != db.SettlementConfirmed {
+			cleared = false
+		}
+	}
+	c.JSON(200, gin.H{"roomId": roomId, "cleared": cleared, "transfers": transfers})
+}
+
+// 获取用户待付、待收的结算转账
+func GetPendingSettlements(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	transfers, err := db.GetPendingSettlements(openId)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, transfers)
+}
+
+type SettlementModel struct {
+	Id int `json:"id"`
+}
+
+// 付款方声明已付款
+func ClaimSettlement(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	var data SettlementModel
+	err := c.Bind(&data)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "body error"})
+		return
+	}
+	before, err := db.GetSettlement(data.Id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	err = db.ClaimSettlement(openId, data.Id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	after, err := db.GetSettlement(data.Id)
+	if err == nil {
+		audit(c, "settlement.claim", before.RoomId, before.FromUser, before, after)
+	}
+	c.String(200, "ok")
+}
+
+// 收款方确认已收款
+func ConfirmSettlement(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	var data SettlementModel
+	err := c.Bind(&data)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "body error"})
+		return
+	}
+	before, err := db.GetSettlement(data.Id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	err = db.ConfirmSettlement(openId, data.Id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	after, err := db.GetSettlement(data.Id)
+	if err == nil {
+		audit(c, "settlement.confirm", before.RoomId, before.FromUser, before, after)
+	}
+	c.String(200, "ok")
+}
+
+// 收款方提醒付款方付款，配置了订阅消息模板时同时推送
+func RemindSettlement(c *gin.Context) {
+	openId := c.Request.Header.Get("openId")
+	var data SettlementModel
+	err := c.Bind(&data)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "body error"})
+		return
+	}
+	s, err := db.RemindSettlement(openId, data.Id)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	audit(c, "settlement.remind", s.RoomId, s.FromUser, nil, gin.H{"id": s.Id, "remindCount": s.RemindCount})
+	pushed := false
+	if config.Config.RemindTemplateId != "" {
+		payee, err := db.QueryUser(s.ToUser)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		err = mp.SendSubscribeMessage(c.Request.Context(), s.FromUser, config.Config.RemindTemplateId, config.Config.RemindPage, map[string]string{
+			"thing1":  payee.Nickname,
+			"number2": strconv.Itoa(s.Points),
+			"thing3":  fmt.Sprintf("房间 %d 结算", s.RoomId),
+		})
+		if err != nil {
+			// 用户未订阅等情况下推送失败，提醒仍然记录在待付列表中
+			logger.FromContext(c.Request.Context()).Error("Error sending remind message", "err", err)
+		} else {
+			pushed = true
+		}
+	}
+	c.JSON(200, gin.H{"remindCount": s.RemindCount, "pushed": pushed})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"scoringMP/config"
+	"scoringMP/service/db"
+	"sync"
+	"time"
+)
+
+// 启动后台任务，ctx 被取消后任务在当前一轮结束时退出，返回的函数等待所有任务退出
+func startJobs(ctx context.Context) (wait func()) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runIdempotencyPurger(ctx, time.Duration(config.Config.IdempotencyWindow)*time.Minute)
+	}()
+	if config.Config.ArchiveAfterDays > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runArchiver(ctx, config.Config.ArchiveAfterDays)
+		}()
+	}
+	return wg.Wait
+}
+
+// 每天归档一次关闭超过 days 天的房间
+func runArchiver(ctx context.Context, days int) {
+	for {
+		archived, err := db.ArchiveRooms(time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			slog.Error("Error archiving rooms", "err", err)
+		}
+		if len(archived) > 0 {
+			slog.Info("Archived rooms", "rooms", archived)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(24 * time.Hour):
+		}
+	}
+}
+
+// 每小时清理一次超过有效期的幂等键，内存存储不保存幂等键时直接退出
+func runIdempotencyPurger(ctx context.Context, window time.Duration) {
+	for {
+		purged, err := db.PurgeIdempotencyKeys(time.Now().Add(-window))
+		if errors.Is(err, db.ErrUnsupported) {
+			return
+		}
+		if err != nil {
+			slog.Error("Error purging idempotency keys", "err", err)
+		}
+		if purged > 0 {
+			slog.Info("Purged idempotency keys", "count", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Hour):
+		}
+	}
+}
+
+// 等待后台任务退出，超过 ctx 的期限时记录日志后不再等待
+func waitJobs(ctx context.Context, wait func()) {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Error("Background jobs did not stop before the shutdown timeout")
+	}
+}
@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// 属性名包含这些词时隐藏属性值
+var sensitiveKeys = []string{"secret", "password", "passwd", "session_key", "sessionkey", "token", "authorization"}
+
+// 链接、表单中的敏感参数，如微信接口地址中的 secret、access_token
+var sensitiveParams = regexp.MustCompile(`(?i)\b(secret|appsecret|access_token|session_key|js_code|password|sig)=[^&\s"']+`)
+
+// 需要从日志中隐藏的配置值，如 AppSecret、数据库密码
+var secrets []string
+
+// 初始化默认日志：level 为 debug、info（默认）、warn、error，format 为 text（默认）或 json。
+// secrets 中的非空值出现在日志的任何位置都会被隐藏
+func Init(level string, format string, secretValues ...string) error {
+	var l slog.Level
+	if level != "" {
+		err := l.UnmarshalText([]byte(level))
+		if err != nil {
+			return err
+		}
+	}
+	secrets = nil
+	for _, s := range secretValues {
+		if s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: l, ReplaceAttr: replaceAttr}
+	var w io.Writer = os.Stdout
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return errors.New("unknown log format: " + format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// 隐藏字符串中的敏感值
+func Redact(s string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return sensitiveParams.ReplaceAllString(s, "$1="+redacted)
+}
+
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, k := range sensitiveKeys {
+		if strings.Contains(key, k) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	switch a.Value.Kind() {
+	case slog.KindString, slog.KindAny:
+		s := a.Value.String()
+		if r := Redact(s); r != s {
+			return slog.String(a.Key, r)
+		}
+	}
+	return a
+}
+
+type contextKey struct{}
+
+// 返回带有 logger 的 context，用于在请求内传递请求 id 等属性
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// 获取 context 中的 logger，没有时返回默认 logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
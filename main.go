@@ -1,27 +1,135 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
 	"scoringMP/config"
+	"scoringMP/logger"
+	"scoringMP/middleware"
 	"scoringMP/routers"
 	"scoringMP/service/db"
+	"scoringMP/service/mp"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	err := run()
+	if err != nil {
+		slog.Error("Fatal error, exiting", "err", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
 	err := config.InitConfig()
 	if err != nil {
-		return
+		return fmt.Errorf("loading config.json: %w", err)
 	}
-	err = db.InitDB()
+	err = logger.Init(config.Config.LogLevel, config.Config.LogFormat, config.Config.AppSecret, config.Config.ReportSecret, config.Config.Database.Password)
 	if err != nil {
-		return
+		return fmt.Errorf("initializing logger: %w", err)
 	}
-	err = db.CreateTables()
+	// 收到 SIGINT/SIGTERM 后取消 ctx：启动阶段停止重试，运行阶段开始优雅退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = db.InitDB(ctx)
 	if err != nil {
-		return
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+	defer func() {
+		err := db.Close()
+		if err != nil {
+			slog.Error("Error closing database", "err", err)
+		}
+	}()
+	// 自动迁移表结构，手动迁移时只检查是否为最新版本
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		if config.Config.ManualMigrate {
+			err = db.CheckMigrations()
+		} else {
+			err = db.Migrate()
+		}
+		if err != nil {
+			return fmt.Errorf("migrating database: %w", err)
+		}
 	}
-	r := gin.Default()
+	// 执行子命令，如 ./main rebuild-leaderboard
+	if len(os.Args) > 1 {
+		err = runCommand(os.Args[1:])
+		if err != nil {
+			return fmt.Errorf("running command %s: %w", os.Args[1], err)
+		}
+		return nil
+	}
+	return serve(ctx)
+}
+
+// 启动 HTTP 服务和后台任务，ctx 被取消后等待处理中的请求和后台任务结束
+func serve(ctx context.Context) error {
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	wait := startJobs(jobsCtx)
+	defer stopJobs()
+
+	// gin 的调试输出也写入结构化日志
+	gin.DebugPrintFunc = func(format string, values ...any) {
+		slog.Debug(strings.TrimSpace(fmt.Sprintf(format, values...)))
+	}
+	r := gin.New()
+	r.Use(middleware.RequestId(), middleware.AccessLog(), middleware.Metrics(), middleware.Recovery())
 	routers.InitRouter(r)
-	r.Run(config.Config.Port)
+
+	c := config.Config.Server
+	srv := &http.Server{
+		Addr:              config.Config.Port,
+		Handler:           r,
+		ReadHeaderTimeout: time.Duration(c.ReadHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(c.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(c.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(c.IdleTimeout) * time.Second,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "addr", srv.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+	shutdownTimeout := time.Duration(c.ShutdownTimeout) * time.Second
+	select {
+	case err := <-serveErr:
+		stopJobs()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		waitJobs(shutdownCtx, wait)
+		return fmt.Errorf("serving http: %w", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	stopJobs()
+	// 停止接受新连接，关闭空闲的 keep-alive 连接，等待处理中的请求完成
+	err := srv.Shutdown(shutdownCtx)
+	// 处理中的请求已结束，关闭与微信接口的连接
+	mp.CloseIdleConnections()
+	// 后台任务与请求共用同一个超时
+	waitJobs(shutdownCtx, wait)
+	if err != nil {
+		srv.Close()
+		return fmt.Errorf("draining requests: %w", err)
+	}
+	err = <-serveErr
+	if !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving http: %w", err)
+	}
+	slog.Info("Server stopped")
+	return nil
 }
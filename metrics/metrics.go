@@ -0,0 +1,277 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 默认的耗时分桶（秒）
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 以 Prometheus 文本格式输出的指标
+type collector interface {
+	write(w io.Writer)
+}
+
+var registry struct {
+	sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+func register(name string, c collector) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.names == nil {
+		registry.names = map[string]bool{}
+	}
+	if registry.names[name] {
+		panic("metric registered twice: " + name)
+	}
+	registry.names[name] = true
+	registry.collectors = append(registry.collectors, c)
+}
+
+// 按 Prometheus 文本格式（0.0.4）输出所有指标
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		registry.Lock()
+		collectors := append([]collector{}, registry.collectors...)
+		registry.Unlock()
+		for _, c := range collectors {
+			c.write(bw)
+		}
+		bw.Flush()
+	})
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// 格式化标签，extra 为附加的标签（如 le）
+func formatLabels(names []string, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extra[i], labelEscaper.Replace(extra[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 按标签值保存的一组序列
+type series[T any] struct {
+	sync.Mutex
+	labels []string
+	values map[string]*T
+	keys   map[string][]string
+}
+
+func (s *series[T]) get(values []string, create func() *T) *T {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(s.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s.Lock()
+	defer s.Unlock()
+	if v, ok := s.values[key]; ok {
+		return v
+	}
+	if s.values == nil {
+		s.values = map[string]*T{}
+		s.keys = map[string][]string{}
+	}
+	v := create()
+	s.values[key] = v
+	s.keys[key] = append([]string{}, values...)
+	return v
+}
+
+// 按标签排序遍历所有序列
+func (s *series[T]) each(fn func(values []string, v *T)) {
+	s.Lock()
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	items := make([]*T, len(keys))
+	labels := make([][]string, len(keys))
+	for i, key := range keys {
+		items[i] = s.values[key]
+		labels[i] = s.keys[key]
+	}
+	s.Unlock()
+	for i := range items {
+		fn(labels[i], items[i])
+	}
+}
+
+type Counter struct {
+	sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	c.Lock()
+	c.value += v
+	c.Unlock()
+}
+
+func (c *Counter) get() float64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.value
+}
+
+// 带标签的计数器
+type CounterVec struct {
+	name string
+	help string
+	series[Counter]
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help}
+	c.labels = labels
+	register(name, c)
+	return c
+}
+
+// 不带标签的计数器
+func NewCounter(name string, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.get(values, func() *Counter { return &Counter{} })
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.each(func(values []string, v *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, values), formatFloat(v.get()))
+	})
+}
+
+type Histogram struct {
+	sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.Lock()
+	defer h.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// 带标签的直方图，buckets 为各分桶的上界
+type HistogramVec struct {
+	name    string
+	help    string
+	buckets []float64
+	series[Histogram]
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, buckets: buckets}
+	h.labels = labels
+	register(name, h)
+	return h
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.get(values, func() *Histogram {
+		return &Histogram{buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+	})
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.each(func(values []string, v *Histogram) {
+		v.Lock()
+		defer v.Unlock()
+		for i, upper := range v.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatFloat(upper)), v.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), v.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(v.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), v.count)
+	})
+}
+
+// 抓取时计算的仪表盘，fn 返回错误时本次不输出
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() (float64, error)
+}
+
+func NewGaugeFunc(name string, help string, fn func() (float64, error)) {
+	register(name, &gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	v, err := g.fn()
+	if err != nil {
+		return
+	}
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(v))
+}
+
+func init() {
+	NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() (float64, error) {
+		return float64(runtime.NumGoroutine()), nil
+	})
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"errors"
+	"scoringMP/logger"
+	"scoringMP/service/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 处理函数在 gin.Context 中设置的审计详情（db.AuditEntry）
+const AuditKey = "audit"
+
+// 成功的 POST/PUT/DELETE 请求写入审计日志，记录操作人、客户端 IP、UA 及请求 id（需在 RequestId 之后使用）。
+// 处理函数通过 AuditKey 设置操作名称、房间、对象及修改前后的值，未设置时操作名称为方法和路由
+func Audit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		if method != "POST" && method != "PUT" && method != "DELETE" {
+			c.Next()
+			return
+		}
+		c.Next()
+		status := c.Writer.Status()
+		if status < 200 || status >= 300 {
+			return
+		}
+		entry := db.AuditEntry{Action: method + " " + c.FullPath()}
+		if value, ok := c.Get(AuditKey); ok {
+			entry = value.(db.AuditEntry)
+		}
+		if entry.Actor == "" {
+			entry.Actor = c.Request.Header.Get("openId")
+		}
+		entry.ClientIp = c.ClientIP()
+		entry.UserAgent = c.Request.UserAgent()
+		entry.RequestId = c.GetString(RequestIdKey)
+		err := db.AppendAudit(entry)
+		if err != nil && !errors.Is(err, db.ErrUnsupported) {
+			// 操作已经成功，审计日志写入失败不影响响应
+			logger.FromContext(c.Request.Context()).Error("Error appending audit log", "err", err)
+		}
+	}
+}
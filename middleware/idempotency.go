@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"scoringMP/config"
+	"scoringMP/service/db"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 记录响应内容，用于保存首次响应
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// 带 Idempotency-Key 请求头的 POST/PUT/DELETE 请求，
+// 有效期内重复请求直接返回首次响应，同一个键用于不同请求时拒绝。
+// 幂等键按 openId 隔离，没有 openId 的请求（如 /login）不做处理，避免不同客户端的键互相命中
+func Idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		method := c.Request.Method
+		openId := c.Request.Header.Get("openId")
+		if key == "" || openId == "" || (method != "POST" && method != "PUT" && method != "DELETE") {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(400, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": "body error"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		// 请求摘要包含方法、路径、查询参数和请求体
+		h := sha256.New()
+		fmt.Fprintf(h, "%s %s\n", method, c.Request.URL.RequestURI())
+		h.Write(body)
+		fingerprint := hex.EncodeToString(h.Sum(nil))
+
+		window := time.Duration(config.Config.IdempotencyWindow) * time.Minute
+		stored, err := db.BeginIdempotent(openId, key, fingerprint, window)
+		switch {
+		case errors.Is(err, db.ErrUnsupported):
+			// 内存存储不保存幂等键
+			c.Next()
+			return
+		case errors.Is(err, db.ErrIdempotencyMismatch):
+			c.AbortWithStatusJSON(422, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, db.ErrIdempotencyInProgress):
+			c.AbortWithStatusJSON(409, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if stored != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.Status, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		saved := false
+		defer func() {
+			// 请求失败（包括 panic）时释放幂等键，失败的请求没有写入数据，客户端可以用同一个键重试
+			if !saved {
+				db.AbortIdempotent(openId, key)
+			}
+		}()
+		c.Next()
+		status := recorder.Status()
+		if status < 200 || status >= 300 {
+			return
+		}
+		err = db.FinishIdempotent(openId, key, db.IdempotentResponse{
+			Status:      status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		})
+		saved = err == nil
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"runtime/debug"
+	"scoringMP/logger"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求 id 在 gin.Context 中的键
+const RequestIdKey = "requestId"
+
+// 客户端传入的请求 id 只接受常见的 id 字符，避免日志注入
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 沿用客户端的 X-Request-ID 或生成新的请求 id，写入响应头，
+// 并在请求 context 中放入带请求 id 的 logger
+func RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if !validRequestId.MatchString(id) {
+			id = newRequestId()
+		}
+		c.Set(RequestIdKey, id)
+		c.Header("X-Request-ID", id)
+		l := slog.Default().With("requestId", id)
+		c.Request = c.Request.WithContext(logger.WithContext(c.Request.Context(), l))
+		c.Next()
+	}
+}
+
+// 不写访问日志的路径，健康检查请求频繁且没有排查价值
+var unloggedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// 记录每个请求的方法、路径、状态码、耗时及 openid。
+// 只记录路径不记录查询参数，查询参数中可能有报告链接签名等敏感值
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		if unloggedPaths[c.Request.URL.Path] {
+			return
+		}
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		logger.FromContext(c.Request.Context()).Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"latency", time.Since(start),
+			"size", c.Writer.Size(),
+			"openid", c.Request.Header.Get("openId"),
+			"ip", c.ClientIP(),
+		)
+	}
+}
+
+// panic 时记录堆栈并返回 500
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		logger.FromContext(c.Request.Context()).Error("Panic recovered", "err", fmt.Sprint(err), "stack", string(debug.Stack()))
+		c.AbortWithStatusJSON(500, gin.H{"error": "internal server error"})
+	})
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"scoringMP/metrics"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("scoring_http_requests_total", "HTTP requests by method, route and status.", "method", "route", "status")
+	httpDuration = metrics.NewHistogramVec("scoring_http_request_duration_seconds", "HTTP request latency by method and route.", metrics.DefBuckets, "method", "route")
+)
+
+// 按路由统计请求数及耗时，未匹配的路由合并为一个标签，避免标签数量无限增长
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequests.With(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.With(method, route).Observe(time.Since(start).Seconds())
+	}
+}
@@ -32,3 +32,28 @@ type Record struct {
 	ToUser     string `json:"toUser"`
 	CreateData string `json:"createData"`
 }
+
+type LedgerPayment struct {
+	Id             int            `json:"id"`
+	Payer          string         `json:"payer"`
+	Payee          string         `json:"payee"`
+	Points         int            `json:"points"`
+	PayerConfirmed bool           `json:"payerConfirmed"`
+	PayeeConfirmed bool           `json:"payeeConfirmed"`
+	CreateData     string         `json:"createData"`
+	ConfirmData    sql.NullString `json:"confirmData"`
+}
+
+type Settlement struct {
+	Id          int            `json:"id"`
+	RoomId      int            `json:"roomId"`
+	FromUser    string         `json:"fromUser"`
+	ToUser      string         `json:"toUser"`
+	Points      int            `json:"points"`
+	Status      string         `json:"status"`
+	RemindCount int            `json:"remindCount"`
+	RemindData  sql.NullString `json:"remindData"`
+	ClaimData   sql.NullString `json:"claimData"`
+	ConfirmData sql.NullString `json:"confirmData"`
+	CreateData  string         `json:"createData"`
+}
@@ -2,12 +2,25 @@ package routers
 
 import (
 	"scoringMP/handles"
+	"scoringMP/metrics"
+	"scoringMP/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 func InitRouter(r *gin.Engine) {
+	// 签名只读报告页面，可在浏览器中打开打印
+	r.GET("/report", handles.GetReport)
+	// 存活、就绪检查，不经过 /api 的中间件
+	r.GET("/healthz", handles.Healthz)
+	r.GET("/readyz", handles.Readyz)
+	// Prometheus 指标
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
 	api := r.Group("/api")
+	// 弱网重试时避免重复计分、重复建房
+	api.Use(middleware.Idempotency())
+	// 重放的幂等请求不重复记录
+	api.Use(middleware.Audit())
 	{
 		api.POST("/login", handles.Login)
 		api.GET("/userRoom", handles.GetUserRoom)
@@ -16,7 +29,34 @@ func InitRouter(r *gin.Engine) {
 		api.POST("/joinRoom", handles.JoinRoom)
 		api.GET("/room", handles.GetRoomDetail)
 		api.POST("/record", handles.AddRecord)
+		api.POST("/record/void", handles.VoidRecord)
 		api.PUT("/nickname", handles.UpdateNickname)
 		api.DELETE("/room", handles.ExitRoom)
+		api.GET("/leaderboard", handles.GetLeaderboard)
+		api.GET("/rating", handles.GetRatings)
+		api.GET("/rating/history", handles.GetRatingHistory)
+		api.GET("/room/rating", handles.GetRoomRating)
+		api.GET("/chart", handles.GetChart)
+		api.GET("/room/chart", handles.GetRoomChart)
+		api.GET("/room/export", handles.ExportRoom)
+		api.GET("/room/card", handles.GetRoomCard)
+		api.GET("/room/report", handles.GetReportLink)
+		api.GET("/room/events", handles.GetRoomEvents)
+		api.PUT("/room/settings", handles.UpdateRoomSettings)
+		api.GET("/room/audit", handles.GetRoomAudit)
+		api.GET("/admin/audit", handles.GetAuditLog)
+		api.GET("/profile", handles.GetProfile)
+		api.GET("/user/export", handles.ExportUserData)
+		api.DELETE("/user", handles.DeleteUser)
+		api.GET("/settlement", handles.GetSettlement)
+		api.GET("/settlement/history", handles.GetSettlementHistory)
+		api.GET("/settlement/pending", handles.GetPendingSettlements)
+		api.PUT("/settlement/claim", handles.ClaimSettlement)
+		api.PUT("/settlement/confirm", handles.ConfirmSettlement)
+		api.POST("/settlement/remind", handles.RemindSettlement)
+		api.GET("/ledger", handles.GetLedger)
+		api.POST("/ledger/payment", handles.CreatePayment)
+		api.PUT("/ledger/payment", handles.ConfirmPayment)
+		api.DELETE("/ledger/payment", handles.CancelPayment)
 	}
 }
@@ -0,0 +1,105 @@
+package achievement
+
+// 用于判断成就的玩家统计
+type Stats struct {
+	// 已结束的对局数
+	Sessions int `json:"sessions"`
+	// 获胜（净得分为正）的对局数
+	Wins int `json:"wins"`
+	// 最长连胜局数
+	WinStreak int `json:"winStreak"`
+	// 单笔记录最大得分
+	BiggestHand int `json:"biggestHand"`
+	// 一起玩过的不同玩家数
+	Peers int `json:"peers"`
+}
+
+// 会改变统计、需要重新检查成就的事件
+type Trigger int
+
+const (
+	// 新增一条记录，只影响收分玩家的 BiggestHand
+	OnRecord Trigger = iota
+	// 对局结束，影响 Sessions、Wins、WinStreak、Peers
+	OnSession
+)
+
+// 成就规则。Unlocked 对统计单调，统计只增不减时达成后不会失效
+type Rule struct {
+	Id          string
+	Name        string
+	Description string
+	Trigger     Trigger
+	Unlocked    func(s Stats) bool
+}
+
+// 所有成就规则，新增成就只需在此追加，Id 一经发布不可修改
+var Rules = []Rule{
+	{
+		Id:          "first_win",
+		Name:        "首胜",
+		Description: "第一次以正分结束对局",
+		Trigger:     OnSession,
+		Unlocked:    func(s Stats) bool { return s.Wins >= 1 },
+	},
+	{
+		Id:          "win_streak_5",
+		Name:        "五连胜",
+		Description: "连续 5 局以正分结束",
+		Trigger:     OnSession,
+		Unlocked:    func(s Stats) bool { return s.WinStreak >= 5 },
+	},
+	{
+		Id:          "big_hand",
+		Name:        "大牌",
+		Description: "单笔记录赢得 100 分及以上",
+		Trigger:     OnRecord,
+		Unlocked:    func(s Stats) bool { return s.BiggestHand >= 100 },
+	},
+	{
+		Id:          "sessions_100",
+		Name:        "百战老将",
+		Description: "累计完成 100 局",
+		Trigger:     OnSession,
+		Unlocked:    func(s Stats) bool { return s.Sessions >= 100 },
+	},
+	{
+		Id:          "peers_20",
+		Name:        "广交牌友",
+		Description: "与 20 位不同的玩家同桌",
+		Trigger:     OnSession,
+		Unlocked:    func(s Stats) bool { return s.Peers >= 20 },
+	},
+}
+
+// 根据 Id 查找规则
+func Find(id string) (Rule, bool) {
+	for _, rule := range Rules {
+		if rule.Id == id {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// 返回统计满足的所有成就
+func Evaluate(s Stats) []Rule {
+	var unlocked []Rule
+	for _, rule := range Rules {
+		if rule.Unlocked(s) {
+			unlocked = append(unlocked, rule)
+		}
+	}
+	return unlocked
+}
+
+// 返回由 trigger 触发且统计满足的成就
+func EvaluateOn(s Stats, trigger Trigger) []Rule {
+	var unlocked []Rule
+	for _, rule := range Rules {
+		if rule.Trigger == trigger && rule.Unlocked(s) {
+			unlocked = append(unlocked, rule)
+		}
+	}
+	return unlocked
+}
@@ -0,0 +1,260 @@
+package card
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"scoringMP/assets"
+	"strconv"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// 分享卡片尺寸，5:4 为微信转发卡片推荐比例
+const (
+	Width  = 1000
+	Height = 800
+)
+
+// 最多展示的玩家数，超出部分合并为一行
+const maxPlayers = 8
+
+type Player struct {
+	Nickname string `json:"nickname"`
+	Score    int    `json:"score"`
+}
+
+// 卡片内容
+type Data struct {
+	Title   string   `json:"title"`
+	Date    string   `json:"date"`
+	Players []Player `json:"players"`
+	// 最大一手，为空时不展示
+	BiggestHand string `json:"biggestHand"`
+}
+
+// 内容版本，内容不变时版本不变
+func (d Data) Version() string {
+	b, _ := json.Marshal(d)
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	background = color.RGBA{0xfb, 0xf7, 0xef, 0xff}
+	header     = color.RGBA{0x1f, 0x6f, 0x4a, 0xff}
+	white      = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	text       = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	gray       = color.RGBA{0x99, 0x99, 0x99, 0xff}
+	win        = color.RGBA{0xd0, 0x3a, 0x2f, 0xff}
+	lose       = color.RGBA{0x2f, 0x8a, 0x4f, 0xff}
+	divider    = color.RGBA{0xe6, 0xdf, 0xd0, 0xff}
+)
+
+var fontCache struct {
+	sync.Mutex
+	path string
+	font *opentype.Font
+}
+
+// 加载字体，支持 ttf、otf 及 ttc、otc 字体集（取第一个字体），path 为空时使用内置字体
+func loadFont(path string) (*opentype.Font, error) {
+	fontCache.Lock()
+	defer fontCache.Unlock()
+	if fontCache.font != nil && fontCache.path == path {
+		return fontCache.font, nil
+	}
+	b := assets.CardFont
+	if path != "" {
+		var err error
+		b, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	collection, err := opentype.ParseCollection(b)
+	if err != nil {
+		return nil, err
+	}
+	f, err := collection.Font(0)
+	if err != nil {
+		return nil, err
+	}
+	fontCache.path = path
+	fontCache.font = f
+	return f, nil
+}
+
+// 绘制文字，align 为 0 左对齐、1 右对齐、2 居中，x 为对齐基准
+func drawText(dst draw.Image, face font.Face, c color.Color, x int, y int, align int, s string) {
+	d := &font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face}
+	width := d.MeasureString(s).Ceil()
+	switch align {
+	case 1:
+		x -= width
+	case 2:
+		x -= width / 2
+	}
+	d.Dot = fixed.P(x, y)
+	d.DrawString(s)
+}
+
+func fill(dst draw.Image, r image.Rectangle, c color.Color) {
+	draw.Draw(dst, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// 渲染战绩卡片为 PNG，玩家需已按名次排序
+func Render(fontPath string, data Data) ([]byte, error) {
+	f, err := loadFont(fontPath)
+	if err != nil {
+		return nil, fmt.Errorf("load card font: %w", err)
+	}
+	faces := make(map[float64]font.Face)
+	face := func(size float64) (font.Face, error) {
+		if faces[size] != nil {
+			return faces[size], nil
+		}
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return nil, err
+		}
+		faces[size] = face
+		return face, nil
+	}
+	defer func() {
+		for _, face := range faces {
+			face.Close()
+		}
+	}()
+	title, err := face(52)
+	if err != nil {
+		return nil, err
+	}
+	normal, err := face(36)
+	if err != nil {
+		return nil, err
+	}
+	small, err := face(28)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	fill(img, img.Bounds(), background)
+	fill(img, image.Rect(0, 0, Width, 160), header)
+	drawText(img, title, white, 60, 90, 0, data.Title)
+	drawText(img, small, white, 60, 135, 0, data.Date)
+
+	players := data.Players
+	more := 0
+	if len(players) > maxPlayers {
+		more = len(players) - maxPlayers + 1
+		players = players[:maxPlayers-1]
+	}
+	rowHeight := 62
+	y := 230
+	for i, p := range players {
+		drawText(img, normal, gray, 80, y, 2, strconv.Itoa(i+1))
+		drawText(img, normal, text, 130, y, 0, p.Nickname)
+		score := strconv.Itoa(p.Score)
+		scoreColor := text
+		if p.Score > 0 {
+			score = "+" + score
+			scoreColor = win
+		} else if p.Score < 0 {
+			scoreColor = lose
+		}
+		drawText(img, normal, scoreColor, Width-60, y, 1, score)
+		fill(img, image.Rect(60, y+18, Width-60, y+20), divider)
+		y += rowHeight
+	}
+	if more > 0 {
+		drawText(img, small, gray, 130, y, 0, fmt.Sprintf("等 %d 人", more))
+	}
+	if data.BiggestHand != "" {
+		drawText(img, small, gray, 60, Height-50, 0, "最大一手："+data.BiggestHand)
+	}
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 最多缓存的房间卡片数，单张约 35KB，合计约 9MB
+const maxCached = 256
+
+type cached struct {
+	roomId  int
+	version string
+	png     []byte
+}
+
+// 按最近使用排序的卡片缓存，超出 maxCached 时淘汰最久未使用的房间
+var cache struct {
+	sync.Mutex
+	order *list.List
+	rooms map[int]*list.Element
+}
+
+func getCached(roomId int, version string) ([]byte, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	e, ok := cache.rooms[roomId]
+	if !ok {
+		return nil, false
+	}
+	c := e.Value.(*cached)
+	if c.version != version {
+		return nil, false
+	}
+	cache.order.MoveToFront(e)
+	return c.png, true
+}
+
+func putCached(roomId int, version string, b []byte) {
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.rooms == nil {
+		cache.order = list.New()
+		cache.rooms = make(map[int]*list.Element)
+	}
+	if e, ok := cache.rooms[roomId]; ok {
+		e.Value = &cached{roomId: roomId, version: version, png: b}
+		cache.order.MoveToFront(e)
+		return
+	}
+	cache.rooms[roomId] = cache.order.PushFront(&cached{roomId: roomId, version: version, png: b})
+	for cache.order.Len() > maxCached {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.rooms, oldest.Value.(*cached).roomId)
+	}
+}
+
+// 获取房间卡片，内容版本未变时直接返回缓存
+func Get(fontPath string, roomId int, data Data) ([]byte, error) {
+	version := data.Version()
+	if b, ok := getCached(roomId, version); ok {
+		return b, nil
+	}
+	b, err := Render(fontPath, data)
+	if err != nil {
+		return nil, err
+	}
+	putCached(roomId, version, b)
+	return b, nil
+}
@@ -0,0 +1,120 @@
+package chart
+
+import (
+	"fmt"
+	"time"
+)
+
+// 时间分桶方式
+const (
+	BucketRecord  = "record"
+	BucketSession = "session"
+	BucketDay     = "day"
+	BucketWeek    = "week"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// 一次得失分
+type Delta struct {
+	Time  string
+	Delta int
+}
+
+// 折线图上的一个点
+type Point struct {
+	// 时间或分桶标签
+	Time string `json:"time"`
+	// 本点内的得失分
+	Delta int `json:"delta"`
+	// 截至本点的累计得分
+	Value int `json:"value"`
+}
+
+// 分桶的标签
+func bucketLabel(t string, bucket string) (string, error) {
+	switch bucket {
+	case BucketRecord, BucketSession:
+		return t, nil
+	}
+	parsed, err := time.ParseInLocation(timeLayout, t, time.Local)
+	if err != nil {
+		return "", err
+	}
+	switch bucket {
+	case BucketDay:
+		return parsed.Format("2006-01-02"), nil
+	case BucketWeek:
+		year, week := parsed.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week), nil
+	}
+	return "", fmt.Errorf("invalid bucket: %s", bucket)
+}
+
+// 按时间顺序的得失分生成累计曲线，同一分桶内的得失分合并为一个点。
+// from、to 为 "2006-01-02 15:04:05" 格式的时间范围，空字符串表示不限制，
+// 范围之前的得失分计入累计值但不输出点
+func Cumulative(deltas []Delta, bucket string, from string, to string) ([]Point, error) {
+	points := []Point{}
+	total := 0
+	for _, d := range deltas {
+		total += d.Delta
+		if (from != "" && d.Time < from) || (to != "" && d.Time > to) {
+			continue
+		}
+		label, err := bucketLabel(d.Time, bucket)
+		if err != nil {
+			return nil, err
+		}
+		last := len(points) - 1
+		if bucket != BucketRecord && bucket != BucketSession && last >= 0 && points[last].Time == label {
+			points[last].Delta += d.Delta
+			points[last].Value = total
+			continue
+		}
+		points = append(points, Point{Time: label, Delta: d.Delta, Value: total})
+	}
+	return points, nil
+}
+
+// 点数超过 max 时按步长合并相邻点，每组取最后一个点的时间和累计值，
+// 得失分为组内之和，保证最后一个点始终保留
+func Downsample(points []Point, max int) []Point {
+	if max <= 0 || len(points) <= max {
+		return points
+	}
+	stride := (len(points) + max - 1) / max
+	result := make([]Point, 0, max)
+	for start := 0; start < len(points); start += stride {
+		end := start + stride
+		if end > len(points) {
+			end = len(points)
+		}
+		p := points[end-1]
+		p.Delta = 0
+		for _, q := range points[start:end] {
+			p.Delta += q.Delta
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// 按下标降采样时需要保留的下标
+func SampleIndexes(n int, max int) []int {
+	indexes := []int{}
+	if max <= 0 || n <= max {
+		for i := 0; i < n; i++ {
+			indexes = append(indexes, i)
+		}
+		return indexes
+	}
+	stride := (n + max - 1) / max
+	for end := stride; end < n+stride; end += stride {
+		if end > n {
+			end = n
+		}
+		indexes = append(indexes, end-1)
+	}
+	return indexes
+}
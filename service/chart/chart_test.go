@@ -0,0 +1,86 @@
+package chart
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCumulative(t *testing.T) {
+	deltas := []Delta{
+		{"2024-01-01 10:00:00", 5},
+		{"2024-01-01 11:00:00", -2},
+		{"2024-01-02 09:00:00", 4},
+		{"2024-01-09 09:00:00", -1},
+	}
+	tests := []struct {
+		name   string
+		bucket string
+		from   string
+		want   []Point
+	}{
+		{"record", BucketRecord, "", []Point{
+			{"2024-01-01 10:00:00", 5, 5},
+			{"2024-01-01 11:00:00", -2, 3},
+			{"2024-01-02 09:00:00", 4, 7},
+			{"2024-01-09 09:00:00", -1, 6},
+		}},
+		{"day", BucketDay, "", []Point{{"2024-01-01", 3, 3}, {"2024-01-02", 4, 7}, {"2024-01-09", -1, 6}}},
+		{"week", BucketWeek, "", []Point{{"2024-W01", 7, 7}, {"2024-W02", -1, 6}}},
+		// 范围之前的得失分计入累计值
+		{"from", BucketDay, "2024-01-02 00:00:00", []Point{{"2024-01-02", 4, 7}, {"2024-01-09", -1, 6}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Cumulative(deltas, tt.bucket, tt.from, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	var points []Point
+	total := 0
+	for i := 1; i <= 10; i++ {
+		total += i
+		points = append(points, Point{Time: string(rune('a' + i - 1)), Delta: i, Value: total})
+	}
+	tests := []struct {
+		max  int
+		want []Point
+	}{
+		{0, points},
+		{10, points},
+		{5, []Point{{"b", 3, 3}, {"d", 7, 10}, {"f", 11, 21}, {"h", 15, 36}, {"j", 19, 55}}},
+		// 步长为 3，最后一组只有一个点，最后一个点仍保留
+		{4, []Point{{"c", 6, 6}, {"f", 15, 21}, {"i", 24, 45}, {"j", 10, 55}}},
+	}
+	for _, tt := range tests {
+		got := Downsample(points, tt.max)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Downsample(max=%d) = %v, want %v", tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestSampleIndexes(t *testing.T) {
+	tests := []struct {
+		n, max int
+		want   []int
+	}{
+		{0, 5, []int{}},
+		{3, 5, []int{0, 1, 2}},
+		{10, 5, []int{1, 3, 5, 7, 9}},
+		{10, 4, []int{2, 5, 8, 9}},
+	}
+	for _, tt := range tests {
+		got := SampleIndexes(tt.n, tt.max)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SampleIndexes(%d, %d) = %v, want %v", tt.n, tt.max, got, tt.want)
+		}
+	}
+}
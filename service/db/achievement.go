@@ -0,0 +1,344 @@
+package db
+
+import (
+	"database/sql"
+	"log/slog"
+	"scoringMP/service/achievement"
+	"sort"
+)
+
+// 统计玩家成就相关数据
+func queryAchievementStats(tx *sql.Tx, openid string) (achievement.Stats, error) {
+	var stats achievement.Stats
+	rows, err := tx.Query(`
+		SELECT s.score
+		FROM all_scores s
+		JOIN rooms r ON s.roomId = r.id
+		WHERE s.openid =? AND r.opened = 0
+		ORDER BY r.createData, r.id
+	`, openid)
+	if err != nil {
+		slog.Error("Error querying player sessions", "err", err)
+		return stats, err
+	}
+	streak := 0
+	for rows.Next() {
+		var score int
+		err = rows.Scan(&score)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning player sessions", "err", err)
+			return stats, err
+		}
+		stats.Sessions++
+		if score > 0 {
+			stats.Wins++
+			streak++
+			stats.WinStreak = max(stats.WinStreak, streak)
+		} else {
+			streak = 0
+		}
+	}
+	rows.Close()
+	err = tx.QueryRow("SELECT COALESCE(MAX(r.score), 0) FROM all_records r WHERE r.toUser =? AND "+notVoided("r"), openid).Scan(&stats.BiggestHand)
+	if err != nil {
+		slog.Error("Error querying biggest hand", "err", err)
+		return stats, err
+	}
+	err = tx.QueryRow("SELECT COUNT(*) FROM player_peers WHERE openid =?", openid).Scan(&stats.Peers)
+	if err != nil {
+		slog.Error("Error querying player peers", "err", err)
+		return stats, err
+	}
+	return stats, nil
+}
+
+// 解锁成就，at 为触发成就的对局或记录时间，为空时使用当前时间。
+// 已解锁的成就保留较早的时间
+func unlockAchievement(tx *sql.Tx, openid string, id string, at string) error {
+	_, err := tx.Exec(dialect.insertIgnore()+" INTO achievements (openid, achievementId, unlockData) VALUES (?,?, COALESCE(?, NOW()))", openid, id, nullString(at))
+	if err != nil {
+		slog.Error("Error inserting achievement", "err", err)
+		return err
+	}
+	if at == "" {
+		return nil
+	}
+	_, err = tx.Exec("UPDATE achievements SET unlockData =? WHERE openid =? AND achievementId =? AND unlockData >?", at, openid, id, at)
+	if err != nil {
+		slog.Error("Error updating achievement", "err", err)
+	}
+	return err
+}
+
+// 新增记录后检查收分玩家的成就，at 为记录时间，为空时使用当前时间。
+// 规则对统计单调，只有本条记录的分数可能让 BiggestHand 达到之前未达到的门槛
+func evaluateRecordAchievements(tx *sql.Tx, toUser string, score int, at string) error {
+	for _, rule := range achievement.EvaluateOn(achievement.Stats{BiggestHand: score}, achievement.OnRecord) {
+		err := unlockAchievement(tx, toUser, rule.Id, at)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 对局结束后检查房间内所有玩家的成就，at 为对局时间，为空时使用当前时间
+func evaluateRoomAchievements(tx *sql.Tx, roomId int, at string) error {
+	rows, err := tx.Query("SELECT openid FROM all_scores WHERE roomId =?", roomId)
+	if err != nil {
+		slog.Error("Error querying room scores", "err", err)
+		return err
+	}
+	var openids []string
+	for rows.Next() {
+		var openid string
+		err = rows.Scan(&openid)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning room scores", "err", err)
+			return err
+		}
+		openids = append(openids, openid)
+	}
+	rows.Close()
+	for _, openid := range openids {
+		stats, err := queryAchievementStats(tx, openid)
+		if err != nil {
+			return err
+		}
+		for _, rule := range achievement.EvaluateOn(stats, achievement.OnSession) {
+			err = unlockAchievement(tx, openid, rule.Id, at)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 玩家历史中改变成就统计的一项：结束的对局或收分记录
+type achievementItem struct {
+	time    string
+	roomId  int
+	session bool
+	score   int
+}
+
+// 按时间回放玩家已结束的对局和收分记录，返回每个成就首次达成的时间。
+// 对局时间为关闭事件的时间
+func replayAchievements(tx *sql.Tx, openid string) (map[string]string, error) {
+	var items []achievementItem
+	rows, err := tx.Query(`
+		SELECT s.roomId, s.score, COALESCE(MAX(e.createData), r.createData)
+		FROM all_scores s
+		JOIN rooms r ON s.roomId = r.id
+		LEFT JOIN all_room_events e ON e.roomId = r.id AND e.type =?
+		WHERE s.openid =? AND r.opened = 0
+		GROUP BY s.roomId, s.score, r.createData
+	`, EventClose, openid)
+	if err != nil {
+		slog.Error("Error querying player sessions", "err", err)
+		return nil, err
+	}
+	for rows.Next() {
+		item := achievementItem{session: true}
+		err = rows.Scan(&item.roomId, &item.score, &item.time)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning player sessions", "err", err)
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	rows, err = tx.Query("SELECT r.score, r.createData FROM all_records r WHERE r.toUser =? AND "+notVoided("r"), openid)
+	if err != nil {
+		slog.Error("Error querying player records", "err", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var item achievementItem
+		err = rows.Scan(&item.score, &item.time)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning player records", "err", err)
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	peers := map[int][]string{}
+	rows, err = tx.Query(`
+		SELECT p.roomId, p.openid
+		FROM all_scores p
+		JOIN all_scores s ON p.roomId = s.roomId
+		WHERE s.openid =? AND p.openid <>?
+	`, openid, openid)
+	if err != nil {
+		slog.Error("Error querying player peers", "err", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var roomId int
+		var peer string
+		err = rows.Scan(&roomId, &peer)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning player peers", "err", err)
+			return nil, err
+		}
+		peers[roomId] = append(peers[roomId], peer)
+	}
+	rows.Close()
+	// 同一时间的记录先于对局结束
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].time != items[j].time {
+			return items[i].time < items[j].time
+		}
+		return !items[i].session && items[j].session
+	})
+	var stats achievement.Stats
+	streak := 0
+	met := map[string]bool{}
+	unlocks := map[string]string{}
+	for _, item := range items {
+		if item.session {
+			stats.Sessions++
+			if item.score > 0 {
+				stats.Wins++
+				streak++
+				stats.WinStreak = max(stats.WinStreak, streak)
+			} else {
+				streak = 0
+			}
+			for _, peer := range peers[item.roomId] {
+				if !met[peer] {
+					met[peer] = true
+					stats.Peers++
+				}
+			}
+		} else {
+			stats.BiggestHand = max(stats.BiggestHand, item.score)
+		}
+		for _, rule := range achievement.Evaluate(stats) {
+			if _, ok := unlocks[rule.Id]; !ok {
+				unlocks[rule.Id] = item.time
+			}
+		}
+	}
+	return unlocks, nil
+}
+
+// 根据已有数据为所有用户补发成就，解锁时间为历史中首次达成的时间
+func BackfillAchievements() error {
+	defer timeQuery("BackfillAchievements")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	rows, err := tx.Query("SELECT openid FROM users")
+	if err != nil {
+		slog.Error("Error querying users", "err", err)
+		return err
+	}
+	var openids []string
+	for rows.Next() {
+		var openid string
+		err = rows.Scan(&openid)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning users", "err", err)
+			return err
+		}
+		openids = append(openids, openid)
+	}
+	rows.Close()
+	for _, openid := range openids {
+		var unlocks map[string]string
+		unlocks, err = replayAchievements(tx, openid)
+		if err != nil {
+			return err
+		}
+		for id, at := range unlocks {
+			err = unlockAchievement(tx, openid, id, at)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type Badge struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	UnlockData  string `json:"unlockData"`
+}
+
+type Profile struct {
+	Openid   string            `json:"openid"`
+	Nickname string            `json:"nickname"`
+	Stats    achievement.Stats `json:"stats"`
+	Badges   []Badge           `json:"badges"`
+}
+
+// 获取用户资料及已解锁的徽章
+func GetProfile(openid string) (Profile, error) {
+	defer timeQuery("GetProfile")()
+	err := sqlOnly()
+	if err != nil {
+		return Profile{}, err
+	}
+	profile := Profile{Openid: openid, Badges: []Badge{}}
+	user, err := QueryUser(openid)
+	if err != nil {
+		return profile, err
+	}
+	profile.Nickname = user.Nickname
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return profile, err
+	}
+	defer tx.Rollback()
+	profile.Stats, err = queryAchievementStats(tx, openid)
+	if err != nil {
+		return profile, err
+	}
+	rows, err := tx.Query("SELECT achievementId, unlockData FROM achievements WHERE openid =? ORDER BY unlockData", openid)
+	if err != nil {
+		slog.Error("Error querying achievements", "err", err)
+		return profile, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var badge Badge
+		err = rows.Scan(&badge.Id, &badge.UnlockData)
+		if err != nil {
+			slog.Error("Error scanning achievements", "err", err)
+			return profile, err
+		}
+		rule, ok := achievement.Find(badge.Id)
+		if !ok {
+			continue
+		}
+		badge.Name = rule.Name
+		badge.Description = rule.Description
+		profile.Badges = append(profile.Badges, badge)
+	}
+	return profile, nil
+}
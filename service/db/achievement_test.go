@@ -0,0 +1,61 @@
+package db
+
+import "testing"
+
+func badgeTimes(t *testing.T, openid string) map[string]string {
+	t.Helper()
+	profile, err := GetProfile(openid)
+	must(t, err)
+	times := map[string]string{}
+	for _, b := range profile.Badges {
+		times[b.Id] = b.UnlockData
+	}
+	return times
+}
+
+// 补发的成就使用历史中首次达成的时间，而不是补发时间
+func TestBackfillAchievementsUsesHistoryTimes(t *testing.T) {
+	useSqlite(t)
+	must(t, RegisterUser("a", "A"))
+	must(t, RegisterUser("b", "B"))
+	for _, stmt := range []string{
+		"INSERT INTO rooms (owner, createData, opened) VALUES ('a', '2024-01-01 10:00:00', 0)",
+		"INSERT INTO scores (openid, roomId, score, createData) VALUES ('a', 1, 110, '2024-01-01 10:00:00'), ('b', 1, -110, '2024-01-01 10:00:00')",
+		"INSERT INTO records (roomId, score, fromUser, toUser, createData) VALUES (1, 120, 'b', 'a', '2024-01-01 10:05:00'), (1, 10, 'a', 'b', '2024-01-01 11:00:00')",
+		// 之前按补发时间写入的成就
+		"INSERT INTO achievements (openid, achievementId, unlockData) VALUES ('a', 'first_win', '2026-01-01 00:00:00')",
+	} {
+		_, err := db.Exec(stmt)
+		must(t, err)
+	}
+	_, err := RebuildRoom(1)
+	must(t, err)
+	must(t, BackfillAchievements())
+
+	got := badgeTimes(t, "a")
+	want := map[string]string{"big_hand": "2024-01-01 10:05:00", "first_win": "2024-01-01 11:00:00"}
+	if len(got) != len(want) || got["big_hand"] != want["big_hand"] || got["first_win"] != want["first_win"] {
+		t.Errorf("got badges %v, want %v", got, want)
+	}
+	if got := badgeTimes(t, "b"); len(got) != 0 {
+		t.Errorf("b got badges %v", got)
+	}
+}
+
+// 计分只检查收分玩家的记录类成就
+func TestAddRecordUnlocksBigHand(t *testing.T) {
+	useSqlite(t)
+	roomId := roomWithRecord(t)
+	must(t, AddRecord(roomId, "a", "b", 100))
+	if got := badgeTimes(t, "b"); len(got) != 1 || got["big_hand"] == "" {
+		t.Errorf("b got badges %v", got)
+	}
+	if got := badgeTimes(t, "a"); len(got) != 0 {
+		t.Errorf("a got badges %v", got)
+	}
+	_, err := QuitRoom("a", roomId)
+	must(t, err)
+	if got := badgeTimes(t, "b"); got["first_win"] == "" {
+		t.Errorf("b got badges %v after the session", got)
+	}
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var ErrRoomArchived = errors.New("room is archived")
+
+// 归档时从在线表移到归档表的数据，按外键依赖顺序删除
+var archivedTables = []struct {
+	Table   string
+	Archive string
+}{
+	{"room_events", "archived_room_events"},
+	{"records", "archived_records"},
+	{"scores", "archived_scores"},
+}
+
+// 房间是否已归档
+func isArchived(q queryer, roomId int) (bool, error) {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM archived_rooms WHERE roomId =?", roomId).Scan(&count)
+	if err != nil {
+		slog.Error("Error querying archived room", "err", err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// 查询关闭时间早于 before 且未归档的房间。关闭时间取关闭事件的时间，
+// 没有事件日志的旧房间取最后一条记录的时间
+func queryArchivableRooms(before time.Time) ([]int, error) {
+	rows, err := db.Query(`
+		SELECT r.id FROM rooms r
+		WHERE r.opened = 0
+		AND NOT EXISTS (SELECT 1 FROM archived_rooms a WHERE a.roomId = r.id)
+		AND COALESCE(
+			(SELECT MAX(e.createData) FROM room_events e WHERE e.roomId = r.id AND e.type =?),
+			(SELECT MAX(c.createData) FROM records c WHERE c.roomId = r.id),
+			r.createData
+		) <?
+		ORDER BY r.id
+	`, EventClose, before.Format(timeLayout))
+	if err != nil {
+		slog.Error("Error querying archivable rooms", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var roomIds []int
+	for rows.Next() {
+		var roomId int
+		err = rows.Scan(&roomId)
+		if err != nil {
+			slog.Error("Error scanning archivable rooms", "err", err)
+			return nil, err
+		}
+		roomIds = append(roomIds, roomId)
+	}
+	return roomIds, nil
+}
+
+// 归档一个已关闭的房间
+func archiveRoom(roomId int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	var opened bool
+	err = tx.QueryRow("SELECT opened FROM rooms WHERE id =?"+dialect.forUpdate(), roomId).Scan(&opened)
+	if err != nil {
+		slog.Error("Error querying room", "err", err)
+		return err
+	}
+	if opened {
+		err = errors.New("room is opened")
+		return err
+	}
+	// 快照可由事件日志重新生成，不归档
+	_, err = tx.Exec("DELETE FROM room_snapshots WHERE roomId =?", roomId)
+	if err != nil {
+		slog.Error("Error deleting room snapshots", "err", err)
+		return err
+	}
+	for _, t := range archivedTables {
+		_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE roomId =?", t.Archive, t.Table), roomId)
+		if err != nil {
+			slog.Error("Error archiving table", "table", t.Table, "err", err)
+			return err
+		}
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE roomId =?", t.Table), roomId)
+		if err != nil {
+			slog.Error("Error deleting archived table", "table", t.Table, "err", err)
+			return err
+		}
+	}
+	_, err = tx.Exec("INSERT INTO archived_rooms (roomId, archiveData) VALUES (?, NOW())", roomId)
+	if err != nil {
+		slog.Error("Error inserting archived room", "err", err)
+		return err
+	}
+	return nil
+}
+
+// 归档关闭时间早于 before 的房间，每个房间一个事务，返回已归档的房间
+func ArchiveRooms(before time.Time) ([]int, error) {
+	defer timeQuery("ArchiveRooms")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	roomIds, err := queryArchivableRooms(before)
+	if err != nil {
+		return nil, err
+	}
+	var archived []int
+	for _, roomId := range roomIds {
+		err = archiveRoom(roomId)
+		if err != nil {
+			return archived, fmt.Errorf("room %d: %w", roomId, err)
+		}
+		archived = append(archived, roomId)
+	}
+	return archived, nil
+}
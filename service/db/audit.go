@@ -0,0 +1,139 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// 审计日志每次查询的最大条数
+const maxAuditLimit = 200
+
+type AuditEntry struct {
+	Id     int    `json:"id"`
+	Actor  string `json:"actor"`
+	Action string `json:"action"`
+	// 为 0 时表示与房间无关
+	RoomId     int             `json:"roomId,omitempty"`
+	TargetUser string          `json:"targetUser,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	ClientIp   string          `json:"clientIp"`
+	UserAgent  string          `json:"userAgent"`
+	RequestId  string          `json:"requestId"`
+	CreateData string          `json:"createData"`
+}
+
+// 追加一条审计日志
+func AppendAudit(e AuditEntry) error {
+	defer timeQuery("AppendAudit")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	if len(e.UserAgent) > 512 {
+		e.UserAgent = e.UserAgent[:512]
+	}
+	if len(e.RequestId) > 64 {
+		e.RequestId = e.RequestId[:64]
+	}
+	_, err = db.Exec(`
+		INSERT INTO audit_log (actor, action, roomId, targetUser, beforeValue, afterValue, clientIp, userAgent, requestId, createData)
+		VALUES (?,?,?,?,?,?,?,?,?, NOW())
+	`, e.Actor, e.Action, nullInt(e.RoomId), nullString(e.TargetUser), nullString(string(e.Before)), nullString(string(e.After)), e.ClientIp, e.UserAgent, e.RequestId)
+	if err != nil {
+		slog.Error("Error inserting audit log", "err", err)
+	}
+	return err
+}
+
+// 审计日志查询条件，空值表示不限制
+type AuditFilter struct {
+	Actor  string
+	Action string
+	RoomId int
+	// 涉及的用户，匹配操作人或操作对象
+	User string
+	// 时间范围，格式同 createData
+	From string
+	To   string
+	// 只返回 id 小于该值的日志，用于翻页
+	BeforeId int
+	Limit    int
+}
+
+// 按条件查询审计日志，按 id 倒序
+func QueryAudit(filter AuditFilter) ([]AuditEntry, error) {
+	defer timeQuery("QueryAudit")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	var conditions []string
+	var args []any
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor =?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action =?")
+		args = append(args, filter.Action)
+	}
+	if filter.RoomId != 0 {
+		conditions = append(conditions, "roomId =?")
+		args = append(args, filter.RoomId)
+	}
+	if filter.User != "" {
+		conditions = append(conditions, "(actor =? OR targetUser =?)")
+		args = append(args, filter.User, filter.User)
+	}
+	if filter.From != "" {
+		conditions = append(conditions, "createData >=?")
+		args = append(args, filter.From)
+	}
+	if filter.To != "" {
+		conditions = append(conditions, "createData <?")
+		args = append(args, filter.To)
+	}
+	if filter.BeforeId > 0 {
+		conditions = append(conditions, "id <?")
+		args = append(args, filter.BeforeId)
+	}
+	if filter.Limit <= 0 || filter.Limit > maxAuditLimit {
+		filter.Limit = maxAuditLimit
+	}
+	query := "SELECT id, actor, action, roomId, targetUser, beforeValue, afterValue, clientIp, userAgent, requestId, createData FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", filter.Limit)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		slog.Error("Error querying audit log", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var roomId sql.NullInt64
+		var targetUser, before, after sql.NullString
+		err = rows.Scan(&e.Id, &e.Actor, &e.Action, &roomId, &targetUser, &before, &after, &e.ClientIp, &e.UserAgent, &e.RequestId, &e.CreateData)
+		if err != nil {
+			slog.Error("Error scanning audit log", "err", err)
+			return nil, err
+		}
+		e.RoomId = int(roomId.Int64)
+		e.TargetUser = targetUser.String
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,401 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 备份文件格式及版本
+const (
+	backupFormat  = "scoringMP-backup"
+	backupVersion = 1
+)
+
+// 备份的表，按外键依赖顺序排列，恢复时依次写入。
+// 幂等键只在短时间内有效，迁移记录由目标库自己维护，均不备份
+var backupTables = []string{
+	"users",
+	"rooms",
+	"room_settings",
+	"scores",
+	"records",
+	"player_rollups",
+	"player_peers",
+	"ratings",
+	"rating_history",
+	"achievements",
+	"ledger_payments",
+	"ledger",
+	"settlements",
+	"room_events",
+	"room_snapshots",
+	"archived_rooms",
+	"archived_scores",
+	"archived_records",
+	"archived_room_events",
+	"audit_log",
+}
+
+// 备份文件的每一行
+type backupLine struct {
+	Type string `json:"type"`
+
+	// header
+	Format        string `json:"format,omitempty"`
+	Version       int    `json:"version,omitempty"`
+	SchemaVersion int    `json:"schemaVersion,omitempty"`
+	Dialect       string `json:"dialect,omitempty"`
+	CreateData    string `json:"createData,omitempty"`
+
+	// row
+	Table string         `json:"table,omitempty"`
+	Row   map[string]any `json:"row,omitempty"`
+
+	// footer
+	Tables []BackupTable `json:"tables,omitempty"`
+}
+
+// 每张表的行数及所有行的 SHA-256
+type BackupTable struct {
+	Table  string `json:"table"`
+	Rows   int    `json:"rows"`
+	Sha256 string `json:"sha256"`
+}
+
+type BackupSummary struct {
+	SchemaVersion int
+	Dialect       string
+	CreateData    string
+	Tables        []BackupTable
+}
+
+// 当前库已执行到的迁移版本，要求所有迁移均已执行
+func currentSchemaVersion(ctx context.Context, q execQueryer) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	applied, err := queryAppliedMigrations(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	err = verifyMigrations(migrations, applied)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			return 0, fmt.Errorf("migration %d_%s is pending, run ./main migrate", m.Version, m.Name)
+		}
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// 将驱动返回的值转换为可移植的 JSON 值：MySQL 的数值列以 []byte 返回，按列类型转为数字
+func portableValue(value any, databaseType string) (any, error) {
+	b, ok := value.([]byte)
+	if !ok {
+		return value, nil
+	}
+	databaseType = strings.ToUpper(databaseType)
+	switch {
+	case strings.Contains(databaseType, "INT"):
+		return strconv.ParseInt(string(b), 10, 64)
+	case strings.Contains(databaseType, "DOUBLE"), strings.Contains(databaseType, "FLOAT"), strings.Contains(databaseType, "REAL"), strings.Contains(databaseType, "DECIMAL"):
+		return strconv.ParseFloat(string(b), 64)
+	}
+	return string(b), nil
+}
+
+// 在一个只读事务中导出所有表，保证各表数据一致
+func Backup(w io.Writer) (BackupSummary, error) {
+	summary := BackupSummary{Dialect: string(dialect), CreateData: time.Now().Format(timeLayout)}
+	err := sqlOnly()
+	if err != nil {
+		return summary, err
+	}
+	ctx := context.Background()
+	opts := &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead}
+	if dialect == sqliteDialect {
+		// SQLite 事务本身是串行的
+		opts = nil
+	}
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return summary, err
+	}
+	defer tx.Rollback()
+	summary.SchemaVersion, err = currentSchemaVersion(ctx, tx)
+	if err != nil {
+		return summary, err
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err = enc.Encode(backupLine{Type: "header", Format: backupFormat, Version: backupVersion, SchemaVersion: summary.SchemaVersion, Dialect: summary.Dialect, CreateData: summary.CreateData})
+	if err != nil {
+		return summary, err
+	}
+	for _, table := range backupTables {
+		t, err := backupTable(tx, bw, table)
+		if err != nil {
+			return summary, err
+		}
+		summary.Tables = append(summary.Tables, t)
+	}
+	err = enc.Encode(backupLine{Type: "footer", Tables: summary.Tables})
+	if err != nil {
+		return summary, err
+	}
+	return summary, bw.Flush()
+}
+
+// 导出一张表的所有行，每行一个 JSON 对象
+func backupTable(tx *sql.Tx, w io.Writer, table string) (BackupTable, error) {
+	t := BackupTable{Table: table}
+	rows, err := tx.Query("SELECT * FROM " + table)
+	if err != nil {
+		slog.Error("Error querying table", "table", table, "err", err)
+		return t, err
+	}
+	defer rows.Close()
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		slog.Error("Error getting columns", "err", err)
+		return t, err
+	}
+	h := sha256.New()
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			slog.Error("Error scanning table", "table", table, "err", err)
+			return t, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			row[column.Name()], err = portableValue(values[i], column.DatabaseTypeName())
+			if err != nil {
+				return t, fmt.Errorf("%s.%s: %w", table, column.Name(), err)
+			}
+		}
+		line, err := json.Marshal(backupLine{Type: "row", Table: table, Row: row})
+		if err != nil {
+			return t, err
+		}
+		line = append(line, '\n')
+		h.Write(line)
+		_, err = w.Write(line)
+		if err != nil {
+			return t, err
+		}
+		t.Rows++
+	}
+	t.Sha256 = hex.EncodeToString(h.Sum(nil))
+	return t, rows.Err()
+}
+
+// JSON 数字转为整数或浮点数
+func restoreValue(value any) any {
+	n, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+// 从备份恢复到空库，目标库的迁移版本必须与备份一致。
+// 所有数据在一个事务中写入，校验和不符时整体回滚；verifyOnly 为 true 时只校验不写入
+func Restore(r io.Reader, verifyOnly bool) (BackupSummary, error) {
+	var summary BackupSummary
+	err := sqlOnly()
+	if err != nil && !verifyOnly {
+		return summary, err
+	}
+	reader := bufio.NewReader(r)
+	header, err := readBackupLine(reader)
+	if err != nil {
+		return summary, err
+	}
+	if header.Type != "header" || header.Format != backupFormat {
+		return summary, errors.New("not a backup file")
+	}
+	if header.Version > backupVersion {
+		return summary, fmt.Errorf("backup format version %d is newer than supported version %d", header.Version, backupVersion)
+	}
+	summary.SchemaVersion, summary.Dialect, summary.CreateData = header.SchemaVersion, header.Dialect, header.CreateData
+
+	var tx *sql.Tx
+	if !verifyOnly {
+		ctx := context.Background()
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			slog.Error("Error starting transaction", "err", err)
+			return summary, err
+		}
+		defer func() {
+			if err != nil {
+				tx.Rollback()
+			}
+		}()
+		var version int
+		version, err = currentSchemaVersion(ctx, tx)
+		if err != nil {
+			return summary, err
+		}
+		if version != header.SchemaVersion {
+			err = fmt.Errorf("backup schema version %d does not match database version %d, run ./main migrate -to %d before restoring", header.SchemaVersion, version, header.SchemaVersion)
+			return summary, err
+		}
+		err = checkEmpty(tx)
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	known := map[string]bool{}
+	for _, table := range backupTables {
+		known[table] = true
+	}
+	hashes := map[string]hash.Hash{}
+	counts := map[string]int{}
+	for {
+		var raw []byte
+		raw, err = reader.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("backup is truncated, footer is missing")
+			}
+			return summary, err
+		}
+		var line backupLine
+		line, err = decodeBackupLine(raw)
+		if err != nil {
+			return summary, err
+		}
+		if line.Type == "footer" {
+			summary.Tables = line.Tables
+			break
+		}
+		if line.Type != "row" || !known[line.Table] {
+			err = fmt.Errorf("unexpected backup line: %s %s", line.Type, line.Table)
+			return summary, err
+		}
+		if hashes[line.Table] == nil {
+			hashes[line.Table] = sha256.New()
+		}
+		hashes[line.Table].Write(raw)
+		counts[line.Table]++
+		if tx != nil {
+			err = restoreRow(tx, line.Table, line.Row)
+			if err != nil {
+				return summary, err
+			}
+		}
+	}
+	for _, t := range summary.Tables {
+		sum := hex.EncodeToString(sha256.New().Sum(nil))
+		if h := hashes[t.Table]; h != nil {
+			sum = hex.EncodeToString(h.Sum(nil))
+		}
+		if counts[t.Table] != t.Rows || sum != t.Sha256 {
+			err = fmt.Errorf("checksum mismatch for table %s: %d rows read, %d expected", t.Table, counts[t.Table], t.Rows)
+			return summary, err
+		}
+		delete(counts, t.Table)
+	}
+	for table := range counts {
+		err = fmt.Errorf("table %s is missing from the backup footer", table)
+		return summary, err
+	}
+	if tx == nil {
+		return summary, nil
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Error("Error committing restore", "err", err)
+	}
+	return summary, err
+}
+
+func readBackupLine(reader *bufio.Reader) (backupLine, error) {
+	raw, err := reader.ReadBytes('\n')
+	if err != nil {
+		return backupLine{}, err
+	}
+	return decodeBackupLine(raw)
+}
+
+func decodeBackupLine(raw []byte) (backupLine, error) {
+	var line backupLine
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	err := dec.Decode(&line)
+	if err != nil {
+		return line, fmt.Errorf("invalid backup line: %w", err)
+	}
+	return line, nil
+}
+
+// 检查所有备份表均为空
+func checkEmpty(tx *sql.Tx) error {
+	for _, table := range backupTables {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count)
+		if err != nil {
+			slog.Error("Error counting table", "table", table, "err", err)
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("table %s is not empty, restore needs an empty database", table)
+		}
+	}
+	return nil
+}
+
+// 列名会拼进 SQL，只允许标识符
+var columnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func restoreRow(tx *sql.Tx, table string, row map[string]any) error {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		if !columnName.MatchString(column) {
+			return fmt.Errorf("invalid column name in backup: %q", column)
+		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	args := make([]any, len(columns))
+	for i, column := range columns {
+		args[i] = restoreValue(row[column])
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",")
+	_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders), args...)
+	if err != nil {
+		slog.Error("Error restoring table", "table", table, "err", err)
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	useSqlite(t)
+	roomId := roomWithRecord(t)
+	var buf bytes.Buffer
+	summary, err := Backup(&buf)
+	must(t, err)
+	backup := buf.Bytes()
+
+	_, err = Restore(bytes.NewReader(backup), true)
+	must(t, err)
+
+	// 恢复到另一个空库
+	conn, err := openSqlite(filepath.Join(t.TempDir(), "restored.db"))
+	must(t, err)
+	setStorage(t, conn, sqliteDialect, &sqlRepository{db: conn})
+	must(t, Migrate())
+	restored, err := Restore(bytes.NewReader(backup), false)
+	must(t, err)
+	if len(restored.Tables) != len(summary.Tables) {
+		t.Errorf("restored %d tables, backup has %d", len(restored.Tables), len(summary.Tables))
+	}
+	scores := roomScores(t, roomId)
+	if scores["a"] != 5 || scores["b"] != -5 {
+		t.Errorf("got restored scores %v", scores)
+	}
+	// 目标库非空时拒绝恢复
+	_, err = Restore(bytes.NewReader(backup), false)
+	if err == nil {
+		t.Error("restore into a non-empty database succeeded")
+	}
+}
+
+func TestRestoreDetectsTampering(t *testing.T) {
+	useSqlite(t)
+	roomWithRecord(t)
+	var buf bytes.Buffer
+	_, err := Backup(&buf)
+	must(t, err)
+	backup := buf.String()
+
+	tests := map[string]string{
+		"modified row": strings.Replace(backup, `"nickname":"B"`, `"nickname":"X"`, 1),
+		"missing row":  removeLine(backup, `"table":"records"`),
+		"truncated":    removeLine(backup, `"type":"footer"`),
+	}
+	for name, tampered := range tests {
+		t.Run(name, func(t *testing.T) {
+			if tampered == backup {
+				t.Fatal("test did not change the backup")
+			}
+			_, err := Restore(strings.NewReader(tampered), true)
+			if err == nil {
+				t.Error("tampered backup passed verification")
+			}
+		})
+	}
+}
+
+// 删除第一行包含 substr 的行
+func removeLine(s string, substr string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if strings.Contains(line, substr) {
+			return strings.Join(append(lines[:i:i], lines[i+1:]...), "")
+		}
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"log/slog"
+	"scoringMP/service/chart"
+)
+
+// 按时间顺序获取玩家每条记录的得失分，与 CheckScores 一致忽略自己给自己的记录，
+// 已作废的记录与冲正记录相互抵消，一并忽略
+func GetPlayerRecordDeltas(openid string) ([]chart.Delta, error) {
+	defer timeQuery("GetPlayerRecordDeltas")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	return readReplica(db, func(q queryer) ([]chart.Delta, error) {
+		var deltas []chart.Delta
+		rows, err := q.Query(`
+			SELECT r.createData, CASE WHEN r.toUser =? THEN r.score ELSE -r.score END
+			FROM all_records r
+			WHERE (r.fromUser =? OR r.toUser =?) AND r.fromUser <> r.toUser AND `+notVoided("r")+`
+			ORDER BY r.createData, r.id
+		`, openid, openid, openid)
+		if err != nil {
+			slog.Error("Error querying player records", "err", err)
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var d chart.Delta
+			err = rows.Scan(&d.Time, &d.Delta)
+			if err != nil {
+				slog.Error("Error scanning player records", "err", err)
+				return nil, err
+			}
+			deltas = append(deltas, d)
+		}
+		return deltas, nil
+	})
+}
+
+// 按时间顺序获取玩家每个已关闭房间的最终得分
+func GetPlayerSessionDeltas(openid string) ([]chart.Delta, error) {
+	defer timeQuery("GetPlayerSessionDeltas")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	return readReplica(db, func(q queryer) ([]chart.Delta, error) {
+		var deltas []chart.Delta
+		rows, err := q.Query(`
+			SELECT r.createData, s.score
+			FROM all_scores s
+			JOIN rooms r ON s.roomId = r.id
+			WHERE s.openid =? AND r.opened = 0
+			ORDER BY r.createData, r.id
+		`, openid)
+		if err != nil {
+			slog.Error("Error querying player sessions", "err", err)
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var d chart.Delta
+			err = rows.Scan(&d.Time, &d.Delta)
+			if err != nil {
+				slog.Error("Error scanning player sessions", "err", err)
+				return nil, err
+			}
+			deltas = append(deltas, d)
+		}
+		return deltas, nil
+	})
+}
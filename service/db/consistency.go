@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"log/slog"
+	"sort"
+)
+
+// 房间内分数与记录汇总不一致的玩家
+type ScoreDrift struct {
+	RoomId int    `json:"roomId"`
+	Openid string `json:"openid"`
+	// scores 表中的分数
+	Score int `json:"score"`
+	// 根据 records 汇总应有的分数
+	Expected int `json:"expected"`
+	// 有记录但没有 scores 行
+	Missing bool `json:"missing"`
+}
+
+type scoreKey struct {
+	roomId int
+	openid string
+}
+
+// 根据 records 计算每个房间每位玩家应有的分数
+func expectedScores(tx *sql.Tx) (map[scoreKey]int, error) {
+	rows, err := tx.Query(`
+		SELECT roomId, toUser, score FROM records WHERE fromUser <> toUser
+		UNION ALL
+		SELECT roomId, fromUser, -score FROM records WHERE fromUser <> toUser
+	`)
+	if err != nil {
+		slog.Error("Error querying records", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	expected := map[scoreKey]int{}
+	for rows.Next() {
+		var key scoreKey
+		var score int
+		err = rows.Scan(&key.roomId, &key.openid, &score)
+		if err != nil {
+			slog.Error("Error scanning records", "err", err)
+			return nil, err
+		}
+		expected[key] += score
+	}
+	return expected, nil
+}
+
+// 根据 records 重算所有房间的分数，返回不一致的玩家，repair 为 true 时修正 scores 表
+func CheckScores(repair bool) ([]ScoreDrift, error) {
+	defer timeQuery("CheckScores")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	expected, err := expectedScores(tx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query("SELECT roomId, openid, score FROM scores ORDER BY roomId, id")
+	if err != nil {
+		slog.Error("Error querying scores", "err", err)
+		return nil, err
+	}
+	drifts := []ScoreDrift{}
+	for rows.Next() {
+		var d ScoreDrift
+		err = rows.Scan(&d.RoomId, &d.Openid, &d.Score)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning scores", "err", err)
+			return nil, err
+		}
+		key := scoreKey{d.RoomId, d.Openid}
+		d.Expected = expected[key]
+		delete(expected, key)
+		if d.Score != d.Expected {
+			drifts = append(drifts, d)
+		}
+	}
+	rows.Close()
+	for key, score := range expected {
+		drifts = append(drifts, ScoreDrift{RoomId: key.roomId, Openid: key.openid, Expected: score, Missing: true})
+	}
+	sort.SliceStable(drifts, func(i, j int) bool {
+		return drifts[i].RoomId < drifts[j].RoomId
+	})
+	if !repair {
+		return drifts, nil
+	}
+	for _, d := range drifts {
+		if d.Missing {
+			_, err = tx.Exec("INSERT INTO scores (openid, roomId, score, createData) VALUES (?,?,0, NOW())", d.Openid, d.RoomId)
+			if err != nil {
+				slog.Error("Error inserting missing score", "err", err)
+				return nil, err
+			}
+		}
+		// 在同一条语句中重新汇总，检查期间新增的记录也会计入
+		_, err = tx.Exec(`
+			UPDATE scores SET score = (
+				SELECT COALESCE(SUM(CASE WHEN r.fromUser = r.toUser THEN 0 WHEN r.toUser = scores.openid THEN r.score ELSE -r.score END), 0)
+				FROM records r
+				WHERE r.roomId = scores.roomId AND (r.toUser = scores.openid OR r.fromUser = scores.openid)
+			)
+			WHERE roomId =? AND openid =?
+		`, d.RoomId, d.Openid)
+		if err != nil {
+			slog.Error("Error repairing score", "err", err)
+			return nil, err
+		}
+	}
+	return drifts, nil
+}
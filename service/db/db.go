@@ -1,150 +1,295 @@
 package db
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"regexp"
 	"scoringMP/config"
 	"scoringMP/model"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
+// 功能表（排行榜、等级分、账本等）使用的 SQL 连接，内存存储时为 nil
 var db *sql.DB
 
-func InitDB() error {
+// 当前 SQL 连接的方言
+var dialect sqlDialect
+
+// 根据配置初始化存储。MySQL 暂时无法连接时（如与数据库同时启动）按指数退避重试，
+// 直到超过 database.connectRetryTimeout 或 ctx 被取消
+func InitDB(ctx context.Context) error {
 	var err error
-	db, err = sql.Open("mysql", config.Config.Mysql)
+	switch config.Config.Storage {
+	case "", StorageMysql:
+		var cfg *mysql.Config
+		cfg, err = mysqlConfig()
+		if err != nil {
+			return err
+		}
+		timeout := time.Duration(config.Config.Database.ConnectRetryTimeout) * time.Second
+		err = retry(ctx, timeout, func() error {
+			db, err = openMysql(cfg)
+			return err
+		})
+		dialect = mysqlDialect
+		if err == nil {
+			replicas, err = openReplicas()
+			if err != nil {
+				db.Close()
+			}
+		}
+	case StorageSqlite:
+		db, err = openSqlite(config.Config.Sqlite)
+		dialect = sqliteDialect
+	case StorageMemory:
+		repo = newMemoryRepository()
+		return nil
+	default:
+		err = errors.New("unknown storage: " + config.Config.Storage)
+		slog.Error("Error initializing storage", "err", err)
+	}
 	if err != nil {
-		fmt.Println("Error opening mysql:", err)
 		return err
 	}
-	err = db.Ping()
+	repo = &sqlRepository{db: db}
+	return loadExpectedSchema()
+}
+
+// 重试的初始间隔和最大间隔
+const (
+	retryInitialDelay = time.Second
+	retryMaxDelay     = 15 * time.Second
+)
+
+// 执行 fn 直到成功，失败后等待的间隔从 retryInitialDelay 起逐次翻倍。
+// 超过 timeout 或 ctx 被取消时返回最后一次的错误
+func retry(ctx context.Context, timeout time.Duration, fn func() error) error {
+	deadline := time.Now().Add(timeout)
+	delay := retryInitialDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		slog.Warn("Error connecting to database, retrying", "attempt", attempt, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, retryMaxDelay)
+	}
+}
+
+// 关闭主库及从库的连接，内存存储时不做任何事
+func Close() error {
+	var errs []error
+	for _, replica := range replicas {
+		errs = append(errs, replica.Close())
+	}
+	replicas = nil
+	if db != nil {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// 检查数据库连接，内存存储时返回 ErrUnsupported
+func Ping(ctx context.Context) error {
+	err := sqlOnly()
 	if err != nil {
-		fmt.Println("Error pinging mysql:", err)
 		return err
 	}
-	// 创建数据库
-	_, err = db.Exec(`CREATE DATABASE IF NOT EXISTS scoring
-			CHARACTER SET utf8mb4
-			COLLATE utf8mb4_unicode_ci
-		;`)
+	return db.PingContext(ctx)
+}
+
+// 根据配置生成 MySQL 连接参数：设置了 database.host 时使用结构化配置，否则解析 mysql 连接字符串
+func mysqlConfig() (*mysql.Config, error) {
+	c := config.Config.Database
+	var cfg *mysql.Config
+	if c.Host != "" {
+		cfg = mysql.NewConfig()
+		cfg.Net = "tcp"
+		cfg.Addr = c.Host
+		cfg.User = c.User
+		cfg.Passwd = c.Password
+	} else {
+		var err error
+		cfg, err = mysql.ParseDSN(config.Config.Mysql)
+		if err != nil {
+			slog.Error("Error parsing mysql dsn", "err", err)
+			return nil, err
+		}
+	}
+	if cfg.DBName == "" {
+		cfg.DBName = c.Name
+	}
+	if !databaseName.MatchString(cfg.DBName) {
+		return nil, fmt.Errorf("invalid database name: %q", cfg.DBName)
+	}
+	if c.TLSCa != "" {
+		pem, err := os.ReadFile(c.TLSCa)
+		if err != nil {
+			slog.Error("Error reading tls ca", "err", err)
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in " + c.TLSCa)
+		}
+		host, _, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			host = cfg.Addr
+		}
+		err = mysql.RegisterTLSConfig("scoring", &tls.Config{RootCAs: pool, ServerName: host})
+		if err != nil {
+			slog.Error("Error registering tls config", "err", err)
+			return nil, err
+		}
+		cfg.TLSConfig = "scoring"
+	} else if c.TLS != "" {
+		cfg.TLSConfig = c.TLS
+	}
+	cfg.Timeout = time.Duration(c.DialTimeout) * time.Second
+	cfg.ReadTimeout = time.Duration(c.ReadTimeout) * time.Second
+	cfg.WriteTimeout = time.Duration(c.WriteTimeout) * time.Second
+	return cfg, nil
+}
+
+// 数据库名会拼进 CREATE DATABASE 语句，只允许标识符
+var databaseName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// 设置连接池大小及连接存活时间
+func configurePool(db *sql.DB) {
+	c := config.Config.Database
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(c.ConnMaxLifetime) * time.Second)
+	db.SetConnMaxIdleTime(time.Duration(c.ConnMaxIdleTime) * time.Second)
+}
+
+func openMysql(cfg *mysql.Config) (*sql.DB, error) {
+	// 不指定数据库连接，创建数据库
+	server := cfg.Clone()
+	server.DBName = ""
+	db, err := sql.Open("mysql", server.FormatDSN())
+	if err != nil {
+		slog.Error("Error opening mysql", "err", err)
+		return nil, err
+	}
+	err = db.Ping()
 	if err != nil {
-		fmt.Println("Error creating database:", err)
-		return err
+		slog.Error("Error pinging mysql", "err", err)
+		db.Close()
+		return nil, err
 	}
+	_, err = db.Exec("CREATE DATABASE IF NOT EXISTS `" + cfg.DBName + "` CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci")
 	db.Close()
+	if err != nil {
+		slog.Error("Error creating database", "err", err)
+		return nil, err
+	}
 	// 连接到数据库
-	db, err = sql.Open("mysql", config.Config.Mysql+"scoring")
+	db, err = sql.Open("mysql", cfg.FormatDSN())
 	if err != nil {
-		fmt.Println("Error opening database:", err)
-		return err
+		slog.Error("Error opening database", "err", err)
+		return nil, err
 	}
+	configurePool(db)
 	err = db.Ping()
 	if err != nil {
-		fmt.Println("Error pinging database:", err)
-		return err
+		slog.Error("Error pinging database", "err", err)
+		db.Close()
+		return nil, err
 	}
-	return nil
+	return db, nil
 }
 
-func CreateTables() error {
-	sqlStatements := []string{
-		`CREATE DATABASE IF NOT EXISTS scoring
-			CHARACTER SET utf8mb4
-			COLLATE utf8mb4_unicode_ci
-		;`,
-		`CREATE TABLE IF NOT EXISTS users (
-			openid VARCHAR(255) PRIMARY KEY,
-			nickname VARCHAR(255) NOT NULL,
-			roomId INT,
-			createData DATETIME NOT NULL
-		);`,
-		`CREATE TABLE IF NOT EXISTS rooms (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			owner VARCHAR(255) NOT NULL,
-			createData DATETIME NOT NULL,
-			opened BOOLEAN NOT NULL,
-			FOREIGN KEY (owner) REFERENCES users(openid)
-		);`,
-		`CREATE TABLE IF NOT EXISTS scores (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			openid VARCHAR(255) NOT NULL,
-			roomId INT NOT NULL,
-			score INT NOT NULL,
-			createData DATETIME NOT NULL,
-			FOREIGN KEY (openid) REFERENCES users(openid),
-			FOREIGN KEY (roomId) REFERENCES rooms(id)
-		);`,
-		`CREATE TABLE IF NOT EXISTS records (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			roomId INT NOT NULL,
-			score INT NOT NULL,
-			fromUser VARCHAR(255) NOT NULL,
-			toUser VARCHAR(255) NOT NULL,
-			createData DATETIME NOT NULL,
-			FOREIGN KEY (roomId) REFERENCES rooms(id),
-			FOREIGN KEY (fromUser) REFERENCES users(openid),
-			FOREIGN KEY (toUser) REFERENCES users(openid)
-		);`,
-	}
-	for _, stmt := range sqlStatements {
-		_, err := db.Exec(stmt)
-		if err != nil {
-			fmt.Println("Error creating tables", err)
-			return err
-		}
-	}
-	return nil
+// 基于 MySQL、SQLite 的存储实现
+type sqlRepository struct {
+	db *sql.DB
 }
 
 // 查询用户
-func QueryUser(openid string) (model.User, error) {
+func (r *sqlRepository) QueryUser(openid string) (model.User, error) {
 	var user model.User
-	err := db.QueryRow("SELECT * FROM users WHERE openid =?", openid).Scan(&user.Openid, &user.Nickname, &user.RoomId, &user.CreateData)
+	err := r.db.QueryRow("SELECT * FROM users WHERE openid =?", openid).Scan(&user.Openid, &user.Nickname, &user.RoomId, &user.CreateData)
 	return user, err
 }
 
 // 注册用户
-func RegisterUser(openid string, nickname string) error {
-	_, err := db.Exec("INSERT INTO users (openid, nickname, createData) VALUES (?, ?, NOW())", openid, nickname)
+func (r *sqlRepository) RegisterUser(openid string, nickname string) error {
+	_, err := r.db.Exec("INSERT INTO users (openid, nickname, createData) VALUES (?, ?, NOW())", openid, nickname)
 	return err
 }
 
 // 查询用户房间
-func QueryUserRoom(openid string) (model.Room, error) {
+func (r *sqlRepository) QueryUserRoom(openid string) (model.Room, error) {
 	var room model.Room
-	err := db.QueryRow("SELECT * FROM rooms WHERE id =(SELECT roomId FROM users WHERE openid =? AND opened = 1)", openid).Scan(&room.Id, &room.Owner, &room.CreateData, &room.Opened)
+	err := r.db.QueryRow("SELECT * FROM rooms WHERE id =(SELECT roomId FROM users WHERE openid =? AND opened = 1)", openid).Scan(&room.Id, &room.Owner, &room.CreateData, &room.Opened)
 	return room, err
 }
 
+type HistoryItem struct {
+	RoomId     int    `json:"roomId"`
+	Score      int    `json:"score"`
+	CreateData string `json:"createData"`
+	Opened     bool   `json:"opened"`
+	// 房间已关闭且所有结算转账都已确认收款
+	Cleared bool `json:"cleared"`
+}
+
 // 查询历史战绩
-func QueryHistory(openid string) ([]int, error) {
-	var scores []int
-	rows, err := db.Query("SELECT score FROM scores WHERE openid =? ORDER BY createData DESC", openid)
-	if err != nil {
-		fmt.Println("Error querying history:", err)
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var score int
-		err = rows.Scan(&score)
+func (r *sqlRepository) QueryHistory(openid string) ([]HistoryItem, error) {
+	return readReplica(r.db, func(q queryer) ([]HistoryItem, error) {
+		scores := []HistoryItem{}
+		rows, err := q.Query(`
+			SELECT s.roomId, s.score, s.createData, r.opened,
+				(SELECT COUNT(*) FROM settlements t WHERE t.roomId = s.roomId AND t.status <> ?) AS unpaid
+			FROM all_scores s
+			JOIN rooms r ON s.roomId = r.id
+			WHERE s.openid =?
+			ORDER BY s.createData DESC
+		`, SettlementConfirmed, openid)
 		if err != nil {
-			fmt.Println("Error scanning history:", err)
+			slog.Error("Error querying history", "err", err)
 			return nil, err
 		}
-	}
-	return scores, nil
+		defer rows.Close()
+		for rows.Next() {
+			var item HistoryItem
+			var unpaid int
+			err = rows.Scan(&item.RoomId, &item.Score, &item.CreateData, &item.Opened, &unpaid)
+			if err != nil {
+				slog.Error("Error scanning history", "err", err)
+				return nil, err
+			}
+			item.Cleared = !item.Opened && unpaid == 0
+			scores = append(scores, item)
+		}
+		return scores, nil
+	})
 }
 
 // 加入房间
-func JoinRoom(openid string, roomId int) error {
+func (r *sqlRepository) JoinRoom(openid string, roomId int) error {
 	// 检查房间是否关闭
 	var opened bool
-	err := db.QueryRow("SELECT opened FROM rooms WHERE id =?", roomId).Scan(&opened)
+	err := r.db.QueryRow("SELECT opened FROM rooms WHERE id =?", roomId).Scan(&opened)
 	if err != nil {
-		fmt.Println("Error querying room opened:", err)
+		slog.Error("Error querying room opened", "err", err)
 		return err
 	}
 	if !opened {
@@ -152,18 +297,18 @@ func JoinRoom(openid string, roomId int) error {
 	}
 	// 检查用户是否已经在房间中
 	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM users WHERE openid =? AND roomId =?", openid, roomId).Scan(&count)
+	err = r.db.QueryRow("SELECT COUNT(*) FROM users WHERE openid =? AND roomId =?", openid, roomId).Scan(&count)
 	if err != nil {
-		fmt.Println("Error querying user in room:", err)
+		slog.Error("Error querying user in room", "err", err)
 		return err
 	}
 	if count > 0 {
 		return errors.New("user already in room")
 	}
 	// 加入房间
-	tx, err := db.Begin()
+	tx, err := r.db.Begin()
 	if err != nil {
-		fmt.Println("Error starting transaction:", err)
+		slog.Error("Error starting transaction", "err", err)
 		return err
 	}
 	defer func() {
@@ -175,13 +320,17 @@ func JoinRoom(openid string, roomId int) error {
 	}()
 	_, err = tx.Exec("UPDATE users SET roomId =? WHERE openid =?", roomId, openid)
 	if err != nil {
-		fmt.Println("Error updating user room:", err)
+		slog.Error("Error updating user room", "err", err)
+		return err
+	}
+	err = appendEvent(tx, roomId, RoomEvent{Type: EventJoin, Openid: openid})
+	if err != nil {
 		return err
 	}
 	// 检查该用户是否已经有该房间的 score 记录
 	err = tx.QueryRow("SELECT COUNT(*) FROM scores WHERE openid =? AND roomId =?", openid, roomId).Scan(&count)
 	if err != nil {
-		fmt.Println("Error querying user score:", err)
+		slog.Error("Error querying user score", "err", err)
 		return err
 	}
 	if count > 0 {
@@ -189,23 +338,23 @@ func JoinRoom(openid string, roomId int) error {
 	}
 	_, err = tx.Exec("INSERT INTO scores (openid, roomId, score, createData) VALUES (?,?,?, NOW())", openid, roomId, 0)
 	if err != nil {
-		fmt.Println("Error inserting user score:", err)
+		slog.Error("Error inserting user score", "err", err)
 		return err
 	}
 	return nil
 }
 
 // 创建/回到房间
-func CreateRoom(openid string) (int, error) {
+func (r *sqlRepository) CreateRoom(openid string, gameType string) (int, error) {
 	// 检查用户是否已经在房间中
-	user, err := QueryUser(openid)
+	user, err := r.QueryUser(openid)
 	if err != nil {
-		fmt.Println("Error querying user:", err)
+		slog.Error("Error querying user", "err", err)
 		return 0, err
 	}
-	tx, err := db.Begin()
+	tx, err := r.db.Begin()
 	if err != nil {
-		fmt.Println("Error starting transaction:", err)
+		slog.Error("Error starting transaction", "err", err)
 		return 0, err
 	}
 	defer func() {
@@ -221,30 +370,50 @@ func CreateRoom(openid string) (int, error) {
 	// 没有房间则创建房间
 	result, err := tx.Exec("INSERT INTO rooms (owner, createData, opened) VALUES (?, NOW(), 1)", openid)
 	if err != nil {
-		fmt.Println("Error inserting room:", err)
+		slog.Error("Error inserting room", "err", err)
 		return 0, err
 	}
 	roomId, err := result.LastInsertId()
 	if err != nil {
-		fmt.Println("Error getting room id:", err)
+		slog.Error("Error getting room id", "err", err)
 		return 0, err
 	}
 	_, err = tx.Exec("UPDATE users SET roomId =? WHERE openid =?", roomId, openid)
 	if err != nil {
-		fmt.Println("Error updating user room:", err)
+		slog.Error("Error updating user room", "err", err)
 		return 0, err
 	}
 	_, err = tx.Exec("INSERT INTO scores (openid, roomId, score, createData) VALUES (?,?,?, NOW())", openid, roomId, 0)
+	if err != nil {
+		slog.Error("Error inserting user score", "err", err)
+		return 0, err
+	}
+	_, err = tx.Exec("INSERT INTO room_settings (roomId, gameType) VALUES (?,?)", roomId, gameType)
+	if err != nil {
+		slog.Error("Error inserting room settings", "err", err)
+		return 0, err
+	}
+	err = appendEvent(tx, int(roomId), RoomEvent{Type: EventCreate, Openid: openid, Payload: settingsPayload(gameType)})
+	if err != nil {
+		return 0, err
+	}
 
 	return int(roomId), nil
 }
 
+// 查询房间
+func (r *sqlRepository) QueryRoom(roomId int) (model.Room, error) {
+	var room model.Room
+	err := r.db.QueryRow("SELECT * FROM rooms WHERE id =?", roomId).Scan(&room.Id, &room.Owner, &room.CreateData, &room.Opened)
+	return room, err
+}
+
 // 检查房间是否关闭
-func CheckRoom(roomId int) (bool, error) {
+func (r *sqlRepository) CheckRoom(roomId int) (bool, error) {
 	var opened bool
-	err := db.QueryRow("SELECT opened FROM rooms WHERE id =?", roomId).Scan(&opened)
+	err := r.db.QueryRow("SELECT opened FROM rooms WHERE id =?", roomId).Scan(&opened)
 	if err != nil {
-		fmt.Println("Error querying room opened:", err)
+		slog.Error("Error querying room opened", "err", err)
 		return false, err
 	}
 	return opened, nil
@@ -257,72 +426,94 @@ type UserScore struct {
 }
 
 // 获取房间用户列表及其 score
-func GetRoomUsers(roomId int) ([]UserScore, error) {
-	var users []UserScore
-	rows, err := db.Query(`
-		SELECT u.openid, u.nickname, s.score
-		FROM scores s
-		JOIN users u ON s.openid = u.openid
-		WHERE s.roomId =?
-		ORDER BY s.createData DESC
-	`, roomId)
-	if err != nil {
-		fmt.Println("Error querying room users:", err)
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var user UserScore
-		err = rows.Scan(&user.Openid, &user.Nickname, &user.Score)
+func (r *sqlRepository) GetRoomUsers(roomId int) ([]UserScore, error) {
+	return readReplica(r.db, func(q queryer) ([]UserScore, error) {
+		var users []UserScore
+		rows, err := q.Query(`
+			SELECT u.openid, u.nickname, s.score
+			FROM all_scores s
+			JOIN users u ON s.openid = u.openid
+			WHERE s.roomId =?
+			ORDER BY s.createData DESC
+		`, roomId)
 		if err != nil {
-			fmt.Println("Error scanning room users:", err)
+			slog.Error("Error querying room users", "err", err)
 			return nil, err
 		}
-		users = append(users, user)
-	}
-	return users, nil
+		defer rows.Close()
+		for rows.Next() {
+			var user UserScore
+			err = rows.Scan(&user.Openid, &user.Nickname, &user.Score)
+			if err != nil {
+				slog.Error("Error scanning room users", "err", err)
+				return nil, err
+			}
+			users = append(users, user)
+		}
+		return users, nil
+	})
 }
 
 type UserRecord struct {
+	Id       int    `json:"id"`
 	FromUser string `json:"fromUser"`
 	ToUser   string `json:"toUser"`
 	Score    int    `json:"score"`
 	Time     string `json:"time"`
 }
 
-// 获取房间分数列表
-func GetRoomRecords(roomId int) ([]UserRecord, error) {
-	var records []UserRecord
-	rows, err := db.Query(`
-		SELECT u1.nickname AS fromUser, u2.nickname AS toUser, r.score, r.createData
-		FROM records r
-		JOIN users u1 ON r.fromUser = u1.openid
-		JOIN users u2 ON r.toUser = u2.openid
-		WHERE r.roomId = ?
-		ORDER BY r.createData DESC
-	`, roomId)
-	if err != nil {
-		fmt.Println("Error querying room records:", err)
-		return nil, err
-	}
-	defer rows.Close()
-	for rows.Next() {
-		var record UserRecord
-		err = rows.Scan(&record.FromUser, &record.ToUser, &record.Score, &record.Time)
+// 获取房间分数列表，不含已作废的记录及其冲正记录
+func (r *sqlRepository) GetRoomRecords(roomId int) ([]UserRecord, error) {
+	return readReplica(r.db, func(q queryer) ([]UserRecord, error) {
+		var records []UserRecord
+		rows, err := q.Query(`
+			SELECT r.id, u1.nickname AS fromUser, u2.nickname AS toUser, r.score, r.createData
+			FROM all_records r
+			JOIN users u1 ON r.fromUser = u1.openid
+			JOIN users u2 ON r.toUser = u2.openid
+			WHERE r.roomId = ? AND `+notVoided("r")+`
+			ORDER BY r.createData DESC
+		`, roomId)
 		if err != nil {
-			fmt.Println("Error scanning room records:", err)
+			slog.Error("Error querying room records", "err", err)
 			return nil, err
 		}
-		records = append(records, record)
-	}
-	return records, nil
+		defer rows.Close()
+		for rows.Next() {
+			var record UserRecord
+			err = rows.Scan(&record.Id, &record.FromUser, &record.ToUser, &record.Score, &record.Time)
+			if err != nil {
+				slog.Error("Error scanning room records", "err", err)
+				return nil, err
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	})
+}
+
+// 获取房间单笔分数最大的记录
+func (r *sqlRepository) GetBiggestRecord(roomId int) (UserRecord, error) {
+	return readReplica(r.db, func(q queryer) (UserRecord, error) {
+		var record UserRecord
+		err := q.QueryRow(`
+			SELECT r.id, u1.nickname AS fromUser, u2.nickname AS toUser, r.score, r.createData
+			FROM all_records r
+			JOIN users u1 ON r.fromUser = u1.openid
+			JOIN users u2 ON r.toUser = u2.openid
+			WHERE r.roomId = ? AND `+notVoided("r")+`
+			ORDER BY r.score DESC, r.createData
+			LIMIT 1
+		`, roomId).Scan(&record.Id, &record.FromUser, &record.ToUser, &record.Score, &record.Time)
+		return record, err
+	})
 }
 
 // 退出房间
-func QuitRoom(openid string, roomId int) (bool, error) {
-	tx, err := db.Begin()
+func (r *sqlRepository) QuitRoom(openid string, roomId int) (bool, error) {
+	tx, err := r.db.Begin()
 	if err != nil {
-		fmt.Println("Error starting transaction:", err)
+		slog.Error("Error starting transaction", "err", err)
 		return false, err
 	}
 	defer func() {
@@ -336,7 +527,7 @@ func QuitRoom(openid string, roomId int) (bool, error) {
 	var count int
 	err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE openid =? AND roomId =?", openid, roomId).Scan(&count)
 	if err != nil {
-		fmt.Println("Error querying user in room:", err)
+		slog.Error("Error querying user in room", "err", err)
 		return false, err
 	}
 	if count == 0 {
@@ -346,14 +537,18 @@ func QuitRoom(openid string, roomId int) (bool, error) {
 	var room model.Room
 	err = tx.QueryRow("SELECT * FROM rooms WHERE id =? AND opened = 1", roomId).Scan(&room.Id, &room.Owner, &room.CreateData, &room.Opened)
 	if err != nil {
-		fmt.Println("Error querying room:", err)
+		slog.Error("Error querying room", "err", err)
 		return false, err
 	}
 	if room.Owner != openid {
 		// 不是房主，直接退出
 		_, err = tx.Exec("UPDATE users SET roomId = NULL WHERE openid =? AND roomId =?", openid, roomId)
 		if err != nil {
-			fmt.Println("Error updating user room:", err)
+			slog.Error("Error updating user room", "err", err)
+			return false, err
+		}
+		err = appendEvent(tx, roomId, RoomEvent{Type: EventLeave, Openid: openid})
+		if err != nil {
 			return false, err
 		}
 		return false, nil
@@ -361,12 +556,36 @@ func QuitRoom(openid string, roomId int) (bool, error) {
 		// 是房主，所有人退出房间，关闭房间
 		_, err = tx.Exec("UPDATE users SET roomId = NULL WHERE roomId =?", roomId)
 		if err != nil {
-			fmt.Println("Error updating user room:", err)
+			slog.Error("Error updating user room", "err", err)
 			return false, err
 		}
 		_, err = tx.Exec("UPDATE rooms SET opened = 0 WHERE id =?", roomId)
 		if err != nil {
-			fmt.Println("Error updating room opened:", err)
+			slog.Error("Error updating room opened", "err", err)
+			return false, err
+		}
+		err = appendEvent(tx, roomId, RoomEvent{Type: EventClose, Openid: openid})
+		if err != nil {
+			return false, err
+		}
+		// 汇总排行榜
+		err = rollupRoom(tx, roomId)
+		if err != nil {
+			return false, err
+		}
+		// 更新等级分
+		err = rateRoom(tx, roomId)
+		if err != nil {
+			return false, err
+		}
+		// 检查成就
+		err = evaluateRoomAchievements(tx, roomId, "")
+		if err != nil {
+			return false, err
+		}
+		// 生成结算转账并记入欠款账本
+		err = ledgerRoom(tx, roomId)
+		if err != nil {
 			return false, err
 		}
 		return true, nil
@@ -374,10 +593,10 @@ func QuitRoom(openid string, roomId int) (bool, error) {
 }
 
 // 计分
-func AddRecord(roomId int, fromUser string, toUser string, score int) error {
-	tx, err := db.Begin()
+func (r *sqlRepository) AddRecord(roomId int, fromUser string, toUser string, score int) error {
+	tx, err := r.db.Begin()
 	if err != nil {
-		fmt.Println("Error starting transaction:", err)
+		slog.Error("Error starting transaction", "err", err)
 		return err
 	}
 	defer func() {
@@ -387,50 +606,46 @@ func AddRecord(roomId int, fromUser string, toUser string, score int) error {
 			tx.Commit()
 		}
 	}()
-	// 查询 fromUser 和 toUser 的 score
-	var fromScore, toScore model.Score
-	err = tx.QueryRow("SELECT * FROM scores WHERE openid =? AND roomId =?", fromUser, roomId).Scan(&fromScore.Id, &fromScore.Openid, &fromScore.RoomId, &fromScore.Score, &fromScore.CreateData)
+	// 原子增减分数
+	err = applyTransfer(tx, roomId, fromUser, toUser, score)
 	if err != nil {
-		fmt.Println("Error querying fromUser score:", err)
 		return err
 	}
-	err = tx.QueryRow("SELECT * FROM scores WHERE openid =? AND roomId =?", toUser, roomId).Scan(&toScore.Id, &toScore.Openid, &toScore.RoomId, &toScore.Score, &toScore.CreateData)
+	// 插入记录
+	result, err := tx.Exec("INSERT INTO records (roomId, score, fromUser, toUser, createData) VALUES (?,?,?,?, NOW())", roomId, score, fromUser, toUser)
 	if err != nil {
-		fmt.Println("Error querying toUser score:", err)
+		slog.Error("Error inserting record", "err", err)
 		return err
 	}
-	// 更新 fromUser 和 toUser 的 score
-	_, err = tx.Exec("UPDATE scores SET score =? WHERE openid =? AND roomId =?", fromScore.Score-score, fromUser, roomId)
+	recordId, err := result.LastInsertId()
 	if err != nil {
-		fmt.Println("Error updating fromUser score:", err)
+		slog.Error("Error getting record id", "err", err)
 		return err
 	}
-	_, err = tx.Exec("UPDATE scores SET score =? WHERE openid =? AND roomId =?", toScore.Score+score, toUser, roomId)
+	err = appendEvent(tx, roomId, RoomEvent{Type: EventRecord, Openid: fromUser, Peer: toUser, Score: score, RecordId: int(recordId)})
 	if err != nil {
-		fmt.Println("Error updating toUser score:", err)
 		return err
 	}
-	// 插入记录
-	_, err = tx.Exec("INSERT INTO records (roomId, score, fromUser, toUser, createData) VALUES (?,?,?,?, NOW())", roomId, score, fromUser, toUser)
+	// 检查成就
+	err = evaluateRecordAchievements(tx, toUser, score, "")
 	if err != nil {
-		fmt.Println("Error inserting record:", err)
 		return err
 	}
 	return nil
 }
 
 // 修改昵称
-func UpdateNickname(openid string, nickname string) error {
-	_, err := db.Exec("UPDATE users SET nickname =? WHERE openid =?", nickname, openid)
+func (r *sqlRepository) UpdateNickname(openid string, nickname string) error {
+	_, err := r.db.Exec("UPDATE users SET nickname =? WHERE openid =?", nickname, openid)
 	return err
 }
 
 // 获取房间内所有用户积分
-func GetRoomScores(roomId int) ([]model.Score, error) {
+func (r *sqlRepository) GetRoomScores(roomId int) ([]model.Score, error) {
 	var scores []model.Score
-	rows, err := db.Query("SELECT * FROM scores WHERE roomId =?", roomId)
+	rows, err := r.db.Query("SELECT * FROM all_scores WHERE roomId =?", roomId)
 	if err != nil {
-		fmt.Println("Error querying room scores:", err)
+		slog.Error("Error querying room scores", "err", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -438,10 +653,47 @@ func GetRoomScores(roomId int) ([]model.Score, error) {
 		var score model.Score
 		err = rows.Scan(&score.Id, &score.Openid, &score.RoomId, &score.Score, &score.CreateData)
 		if err != nil {
-			fmt.Println("Error scanning room scores:", err)
+			slog.Error("Error scanning room scores", "err", err)
 			return nil, err
 		}
 		scores = append(scores, score)
 	}
 	return scores, nil
 }
+
+// 按时间顺序获取房间内的原始记录，不含已作废的记录及其冲正记录
+func (r *sqlRepository) GetRoomRecordRows(roomId int) ([]model.Record, error) {
+	return readReplica(r.db, func(q queryer) ([]model.Record, error) {
+		var records []model.Record
+		rows, err := q.Query("SELECT r.* FROM all_records r WHERE r.roomId =? AND "+notVoided("r")+" ORDER BY r.createData, r.id", roomId)
+		if err != nil {
+			slog.Error("Error querying room records", "err", err)
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var record model.Record
+			err = rows.Scan(&record.Id, &record.RoomId, &record.Score, &record.FromUser, &record.ToUser, &record.CreateData)
+			if err != nil {
+				slog.Error("Error scanning room records", "err", err)
+				return nil, err
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	})
+}
+
+// 查询房间玩法
+func (r *sqlRepository) GetRoomGameType(roomId int) (string, error) {
+	var gameType string
+	err := r.db.QueryRow("SELECT gameType FROM room_settings WHERE roomId =?", roomId).Scan(&gameType)
+	if err == sql.ErrNoRows {
+		return DefaultGameType, nil
+	}
+	if err != nil {
+		slog.Error("Error querying room game type", "err", err)
+		return "", err
+	}
+	return gameType, nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// 测试使用临时 SQLite 库，结束后恢复包级连接
+func useSqlite(t *testing.T) {
+	t.Helper()
+	conn, err := openSqlite(filepath.Join(t.TempDir(), "scoring.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setStorage(t, conn, sqliteDialect, &sqlRepository{db: conn})
+	err = Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 测试使用内存存储
+func useMemory(t *testing.T) {
+	t.Helper()
+	setStorage(t, nil, "", newMemoryRepository())
+}
+
+func setStorage(t *testing.T, conn *sql.DB, d sqlDialect, r Repository) {
+	oldDb, oldDialect, oldRepo, oldReplicas := db, dialect, repo, replicas
+	db, dialect, repo, replicas = conn, d, r, nil
+	t.Cleanup(func() {
+		if conn != nil {
+			conn.Close()
+		}
+		db, dialect, repo, replicas = oldDb, oldDialect, oldRepo, oldReplicas
+	})
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 按 openid 取房间内的分数
+func roomScores(t *testing.T, roomId int) map[string]int {
+	t.Helper()
+	users, err := GetRoomUsers(roomId)
+	must(t, err)
+	scores := map[string]int{}
+	for _, u := range users {
+		scores[u.Openid] = u.Score
+	}
+	return scores
+}
+
+// 建一个 a 赢 b 5 分的房间，由 a 创建
+func roomWithRecord(t *testing.T) int {
+	t.Helper()
+	must(t, RegisterUser("a", "A"))
+	must(t, RegisterUser("b", "B"))
+	roomId, err := CreateRoom("a", DefaultGameType)
+	must(t, err)
+	must(t, JoinRoom("b", roomId))
+	must(t, AddRecord(roomId, "b", "a", 5))
+	return roomId
+}
+
+func TestRepositoryRoundTrip(t *testing.T) {
+	backends := map[string]func(t *testing.T){"memory": useMemory, "sqlite": useSqlite}
+	for name, use := range backends {
+		t.Run(name, func(t *testing.T) {
+			use(t)
+			must(t, RegisterUser("a", "用户a"))
+			must(t, RegisterUser("b", "用户b"))
+			must(t, UpdateNickname("b", "小b"))
+			user, err := QueryUser("b")
+			must(t, err)
+			if user.Nickname != "小b" {
+				t.Errorf("got nickname %q", user.Nickname)
+			}
+
+			roomId, err := CreateRoom("a", DefaultGameType)
+			must(t, err)
+			must(t, JoinRoom("b", roomId))
+			room, err := QueryUserRoom("b")
+			must(t, err)
+			if room.Id != roomId || room.Owner != "a" || !room.Opened {
+				t.Errorf("got room %+v", room)
+			}
+
+			must(t, AddRecord(roomId, "b", "a", 5))
+			must(t, AddRecord(roomId, "a", "b", 2))
+			scores := roomScores(t, roomId)
+			if scores["a"] != 3 || scores["b"] != -3 {
+				t.Errorf("got scores %v", scores)
+			}
+			records, err := GetRoomRecords(roomId)
+			must(t, err)
+			if len(records) != 2 {
+				t.Errorf("got %d records", len(records))
+			}
+			biggest, err := GetBiggestRecord(roomId)
+			must(t, err)
+			if biggest.Score != 5 || biggest.ToUser != "用户a" || biggest.FromUser != "小b" {
+				t.Errorf("got biggest record %+v", biggest)
+			}
+
+			closed, err := QuitRoom("b", roomId)
+			must(t, err)
+			if closed {
+				t.Error("member leaving closed the room")
+			}
+			closed, err = QuitRoom("a", roomId)
+			must(t, err)
+			if !closed {
+				t.Error("owner leaving did not close the room")
+			}
+			room, err = QueryRoom(roomId)
+			must(t, err)
+			if room.Opened {
+				t.Error("room is still open")
+			}
+			history, err := QueryHistory("a")
+			must(t, err)
+			if len(history) != 1 || history[0].RoomId != roomId || history[0].Score != 3 || history[0].Opened {
+				t.Errorf("got history %+v", history)
+			}
+		})
+	}
+}
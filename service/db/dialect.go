@@ -0,0 +1,41 @@
+package db
+
+// SQL 方言，只覆盖功能表用到的 MySQL 与 SQLite 差异
+type sqlDialect string
+
+const (
+	mysqlDialect  sqlDialect = "mysql"
+	sqliteDialect sqlDialect = "sqlite"
+)
+
+// 行锁，SQLite 的写事务本身串行执行，不需要
+func (d sqlDialect) forUpdate() string {
+	if d == sqliteDialect {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+// 主键冲突时忽略插入
+func (d sqlDialect) insertIgnore() string {
+	if d == sqliteDialect {
+		return "INSERT OR IGNORE"
+	}
+	return "INSERT IGNORE"
+}
+
+// 主键冲突时更新，keys 为冲突的主键列
+func (d sqlDialect) onConflict(keys string) string {
+	if d == sqliteDialect {
+		return "ON CONFLICT (" + keys + ") DO UPDATE SET"
+	}
+	return "ON DUPLICATE KEY UPDATE"
+}
+
+// 冲突更新时引用待插入的值
+func (d sqlDialect) excluded(column string) string {
+	if d == sqliteDialect {
+		return "excluded." + column
+	}
+	return "VALUES(" + column + ")"
+}
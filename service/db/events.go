@@ -0,0 +1,660 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// 房间事件类型
+const (
+	EventCreate   = "create"
+	EventJoin     = "join"
+	EventLeave    = "leave"
+	EventRecord   = "record"
+	EventVoid     = "void"
+	EventSettings = "settings"
+	EventClose    = "close"
+)
+
+// 每隔多少个事件保存一次房间快照
+const snapshotInterval = 20
+
+// 房间事件，seq 在房间内从 1 开始递增
+type RoomEvent struct {
+	Seq  int    `json:"seq"`
+	Type string `json:"type"`
+	// create/join/leave/close 为操作的玩家，record/void 为付分的玩家
+	Openid string `json:"openid"`
+	// record/void 为收分的玩家
+	Peer     string `json:"peer"`
+	Score    int    `json:"score"`
+	RecordId int    `json:"recordId"`
+	// create/settings 为房间设置，void 为冲正记录 id
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	CreateData string          `json:"createData"`
+}
+
+type RoomSettings struct {
+	GameType string `json:"gameType"`
+}
+
+type voidPayload struct {
+	ReversalId int64 `json:"reversalId"`
+}
+
+// 排除已作废的记录及其冲正记录的查询条件，alias 为 all_records 的别名。
+// 作废事件的 recordId 为原记录，payload 中的 reversalId 为冲正记录
+func notVoided(alias string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+		SELECT 1 FROM all_room_events v
+		WHERE v.roomId = %[1]s.roomId AND v.type = '%[2]s'
+		AND (v.recordId = %[1]s.id OR JSON_EXTRACT(v.payload, '$.reversalId') = %[1]s.id)
+	)`, alias, EventVoid)
+}
+
+type PlayerState struct {
+	Openid string `json:"openid"`
+	Score  int    `json:"score"`
+	// 是否仍在房间中
+	Present bool `json:"present"`
+}
+
+// 房间事件回放得到的状态
+type RoomState struct {
+	RoomId   int           `json:"roomId"`
+	Seq      int           `json:"seq"`
+	GameType string        `json:"gameType"`
+	Opened   bool          `json:"opened"`
+	Records  int           `json:"records"`
+	Players  []PlayerState `json:"players"`
+}
+
+func (s *RoomState) player(openid string) *PlayerState {
+	for i := range s.Players {
+		if s.Players[i].Openid == openid {
+			return &s.Players[i]
+		}
+	}
+	s.Players = append(s.Players, PlayerState{Openid: openid})
+	return &s.Players[len(s.Players)-1]
+}
+
+// 应用一个事件
+func (s *RoomState) apply(e RoomEvent) error {
+	if e.Seq != s.Seq+1 {
+		return fmt.Errorf("room %d: event %d follows %d", s.RoomId, e.Seq, s.Seq)
+	}
+	s.Seq = e.Seq
+	switch e.Type {
+	case EventCreate, EventSettings:
+		var settings RoomSettings
+		err := json.Unmarshal(e.Payload, &settings)
+		if err != nil {
+			return err
+		}
+		s.GameType = settings.GameType
+		if e.Type == EventCreate {
+			s.Opened = true
+			s.player(e.Openid).Present = true
+		}
+	case EventJoin:
+		s.player(e.Openid).Present = true
+	case EventLeave:
+		s.player(e.Openid).Present = false
+	case EventRecord:
+		s.player(e.Openid).Score -= e.Score
+		s.player(e.Peer).Score += e.Score
+		s.Records++
+	case EventVoid:
+		s.player(e.Openid).Score += e.Score
+		s.player(e.Peer).Score -= e.Score
+	case EventClose:
+		s.Opened = false
+		for i := range s.Players {
+			s.Players[i].Present = false
+		}
+	default:
+		return errors.New("unknown room event: " + e.Type)
+	}
+	return nil
+}
+
+// *sql.DB 与 *sql.Tx 的查询方法
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// 查询房间 seq 在 (after, upTo] 之间的事件，upTo 为 0 时不限
+func queryRoomEvents(q queryer, roomId int, after int, upTo int) ([]RoomEvent, error) {
+	query := "SELECT seq, type, openid, peer, score, recordId, payload, createData FROM all_room_events WHERE roomId =? AND seq >?"
+	args := []any{roomId, after}
+	if upTo > 0 {
+		query += " AND seq <=?"
+		args = append(args, upTo)
+	}
+	rows, err := q.Query(query+" ORDER BY seq", args...)
+	if err != nil {
+		slog.Error("Error querying room events", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	events := []RoomEvent{}
+	for rows.Next() {
+		var e RoomEvent
+		var openid, peer, payload sql.NullString
+		var recordId sql.NullInt64
+		err = rows.Scan(&e.Seq, &e.Type, &openid, &peer, &e.Score, &recordId, &payload, &e.CreateData)
+		if err != nil {
+			slog.Error("Error scanning room events", "err", err)
+			return nil, err
+		}
+		e.Openid, e.Peer, e.RecordId = openid.String, peer.String, int(recordId.Int64)
+		if payload.Valid {
+			e.Payload = json.RawMessage(payload.String)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// 从最近的快照开始回放，得到房间在 upTo 时的状态，upTo 为 0 时为最新状态
+func loadRoomState(q queryer, roomId int, upTo int) (RoomState, error) {
+	state := RoomState{RoomId: roomId, Players: []PlayerState{}}
+	query := "SELECT state FROM room_snapshots WHERE roomId =?"
+	args := []any{roomId}
+	if upTo > 0 {
+		query += " AND seq <=?"
+		args = append(args, upTo)
+	}
+	var snapshot string
+	err := q.QueryRow(query+" ORDER BY seq DESC LIMIT 1", args...).Scan(&snapshot)
+	if err == nil {
+		err = json.Unmarshal([]byte(snapshot), &state)
+	} else if err == sql.ErrNoRows {
+		err = nil
+	}
+	if err != nil {
+		slog.Error("Error loading room snapshot", "err", err)
+		return state, err
+	}
+	events, err := queryRoomEvents(q, roomId, state.Seq, upTo)
+	if err != nil {
+		return state, err
+	}
+	for _, e := range events {
+		err = state.apply(e)
+		if err != nil {
+			return state, err
+		}
+	}
+	return state, nil
+}
+
+// 保存房间快照
+func saveSnapshot(tx *sql.Tx, state RoomState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(dialect.insertIgnore()+" INTO room_snapshots (roomId, seq, state, createData) VALUES (?,?,?, NOW())", state.RoomId, state.Seq, string(data))
+	if err != nil {
+		slog.Error("Error saving room snapshot", "err", err)
+	}
+	return err
+}
+
+// 追加房间事件。先锁住房间行，保证同一房间的事件按顺序编号；
+// CreateData 为空时使用当前时间
+func appendEvent(tx *sql.Tx, roomId int, e RoomEvent) error {
+	var id int
+	err := tx.QueryRow("SELECT id FROM rooms WHERE id =?"+dialect.forUpdate(), roomId).Scan(&id)
+	if err != nil {
+		slog.Error("Error locking room", "err", err)
+		return err
+	}
+	err = tx.QueryRow("SELECT COALESCE(MAX(seq), 0) + 1 FROM room_events WHERE roomId =?", roomId).Scan(&e.Seq)
+	if err != nil {
+		slog.Error("Error querying room event seq", "err", err)
+		return err
+	}
+	err = insertEvent(tx, roomId, e)
+	if err != nil {
+		return err
+	}
+	if e.Seq%snapshotInterval != 0 {
+		return nil
+	}
+	state, err := loadRoomState(tx, roomId, e.Seq)
+	if err != nil {
+		return err
+	}
+	return saveSnapshot(tx, state)
+}
+
+// 写入一条已编号的房间事件，CreateData 为空时使用当前时间
+func insertEvent(tx *sql.Tx, roomId int, e RoomEvent) error {
+	var payload sql.NullString
+	if e.Payload != nil {
+		payload = sql.NullString{String: string(e.Payload), Valid: true}
+	}
+	_, err := tx.Exec(`
+		INSERT INTO room_events (roomId, seq, type, openid, peer, score, recordId, payload, createData)
+		VALUES (?,?,?,?,?,?,?,?, COALESCE(?, NOW()))
+	`, roomId, e.Seq, e.Type, nullString(e.Openid), nullString(e.Peer), e.Score, nullInt(e.RecordId), payload, nullString(e.CreateData))
+	if err != nil {
+		slog.Error("Error inserting room event", "err", err)
+	}
+	return err
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+func settingsPayload(gameType string) json.RawMessage {
+	data, _ := json.Marshal(RoomSettings{GameType: gameType})
+	return data
+}
+
+// 原子增减两位玩家的分数，按 openid 顺序更新，避免两条方向相反的记录互相等待行锁
+func applyTransfer(tx *sql.Tx, roomId int, fromUser string, toUser string, score int) error {
+	updates := []struct {
+		openid string
+		delta  int
+	}{{fromUser, -score}, {toUser, score}}
+	if toUser < fromUser {
+		updates[0], updates[1] = updates[1], updates[0]
+	}
+	for _, u := range updates {
+		result, err := tx.Exec("UPDATE scores SET score = score + ? WHERE openid =? AND roomId =?", u.delta, u.openid, roomId)
+		if err != nil {
+			slog.Error("Error updating score", "err", err)
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err == nil && affected == 0 {
+			err = sql.ErrNoRows
+		}
+		if err != nil {
+			slog.Error("Error updating score", "openid", u.openid, "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// 检查房间开启且 openid 为房主
+func checkRoomOwner(tx *sql.Tx, openid string, roomId int) error {
+	var owner string
+	var opened bool
+	err := tx.QueryRow("SELECT owner, opened FROM rooms WHERE id =?"+dialect.forUpdate(), roomId).Scan(&owner, &opened)
+	if err != nil {
+		slog.Error("Error querying room", "err", err)
+		return err
+	}
+	if owner != openid {
+		return errors.New("only owner can change room")
+	}
+	if !opened {
+		return errors.New("room is not opened")
+	}
+	return nil
+}
+
+// 作废一条记录：追加一条方向相反的冲正记录，原记录保留，并记录作废事件
+func VoidRecord(openid string, roomId int, recordId int) error {
+	defer timeQuery("VoidRecord")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	err = checkRoomOwner(tx, openid, roomId)
+	if err != nil {
+		return err
+	}
+	// 只能作废计分事件对应的记录，冲正记录本身不能再作废
+	var record RoomEvent
+	err = tx.QueryRow("SELECT openid, peer, score FROM room_events WHERE roomId =? AND recordId =? AND type =?", roomId, recordId, EventRecord).Scan(&record.Openid, &record.Peer, &record.Score)
+	if err == sql.ErrNoRows {
+		err = errors.New("record is not exist")
+	}
+	if err != nil {
+		return err
+	}
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM room_events WHERE roomId =? AND recordId =? AND type =?", roomId, recordId, EventVoid).Scan(&count)
+	if err != nil {
+		slog.Error("Error querying void event", "err", err)
+		return err
+	}
+	if count > 0 {
+		err = errors.New("record is already voided")
+		return err
+	}
+	err = applyTransfer(tx, roomId, record.Peer, record.Openid, record.Score)
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec("INSERT INTO records (roomId, score, fromUser, toUser, createData) VALUES (?,?,?,?, NOW())", roomId, record.Score, record.Peer, record.Openid)
+	if err != nil {
+		slog.Error("Error inserting reversal record", "err", err)
+		return err
+	}
+	reversalId, err := result.LastInsertId()
+	if err != nil {
+		slog.Error("Error getting reversal record id", "err", err)
+		return err
+	}
+	payload, err := json.Marshal(voidPayload{ReversalId: reversalId})
+	if err != nil {
+		return err
+	}
+	return appendEvent(tx, roomId, RoomEvent{Type: EventVoid, Openid: record.Openid, Peer: record.Peer, Score: record.Score, RecordId: recordId, Payload: payload})
+}
+
+// 修改房间设置
+func UpdateRoomSettings(openid string, roomId int, gameType string) error {
+	defer timeQuery("UpdateRoomSettings")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	err = checkRoomOwner(tx, openid, roomId)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`
+		INSERT INTO room_settings (roomId, gameType) VALUES (?,?)
+		%s gameType = %s
+	`, dialect.onConflict("roomId"), dialect.excluded("gameType")), roomId, gameType)
+	if err != nil {
+		slog.Error("Error updating room settings", "err", err)
+		return err
+	}
+	return appendEvent(tx, roomId, RoomEvent{Type: EventSettings, Openid: openid, Payload: settingsPayload(gameType)})
+}
+
+// 获取房间 seq 之后的事件
+func GetRoomEvents(roomId int, after int) ([]RoomEvent, error) {
+	defer timeQuery("GetRoomEvents")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	return queryRoomEvents(db, roomId, after, 0)
+}
+
+// 获取房间在 seq 时的状态，seq 为 0 时为最新状态
+func GetRoomState(roomId int, seq int) (RoomState, error) {
+	defer timeQuery("GetRoomState")()
+	err := sqlOnly()
+	if err != nil {
+		return RoomState{}, err
+	}
+	return loadRoomState(db, roomId, seq)
+}
+
+// 为所有没有事件日志的房间补写事件（迁移 0007 的数据处理）。
+// 之后创建的房间都有创建事件，appendEvent 不会遇到没有历史的房间
+func backfillAllRoomEvents(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id FROM rooms r WHERE NOT EXISTS (SELECT 1 FROM room_events e WHERE e.roomId = r.id) ORDER BY id")
+	if err != nil {
+		slog.Error("Error querying rooms without events", "err", err)
+		return err
+	}
+	var roomIds []int
+	for rows.Next() {
+		var roomId int
+		err = rows.Scan(&roomId)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning rooms", "err", err)
+			return err
+		}
+		roomIds = append(roomIds, roomId)
+	}
+	rows.Close()
+	for _, roomId := range roomIds {
+		err = backfillRoomEvents(tx, roomId)
+		if err != nil {
+			return err
+		}
+	}
+	if len(roomIds) > 0 {
+		slog.Info("Backfilled room events", "rooms", len(roomIds))
+	}
+	return nil
+}
+
+// 为没有事件日志的旧房间根据 scores、records 补写事件
+func backfillRoomEvents(tx *sql.Tx, roomId int) error {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM room_events WHERE roomId =?", roomId).Scan(&count)
+	if err != nil {
+		slog.Error("Error counting room events", "err", err)
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	var owner, createData string
+	var opened bool
+	err = tx.QueryRow("SELECT owner, createData, opened FROM rooms WHERE id =?", roomId).Scan(&owner, &createData, &opened)
+	if err != nil {
+		slog.Error("Error querying room", "err", err)
+		return err
+	}
+	gameType, err := queryGameType(tx, roomId)
+	if err != nil {
+		return err
+	}
+	events := []RoomEvent{{Type: EventCreate, Openid: owner, Payload: settingsPayload(gameType), CreateData: createData}}
+	rows, err := tx.Query("SELECT openid, createData FROM scores WHERE roomId =? AND openid <>? ORDER BY id", roomId, owner)
+	if err != nil {
+		slog.Error("Error querying room scores", "err", err)
+		return err
+	}
+	for rows.Next() {
+		e := RoomEvent{Type: EventJoin}
+		err = rows.Scan(&e.Openid, &e.CreateData)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning room scores", "err", err)
+			return err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	rows, err = tx.Query("SELECT id, fromUser, toUser, score, createData FROM records WHERE roomId =? ORDER BY id", roomId)
+	if err != nil {
+		slog.Error("Error querying room records", "err", err)
+		return err
+	}
+	for rows.Next() {
+		e := RoomEvent{Type: EventRecord}
+		err = rows.Scan(&e.RecordId, &e.Openid, &e.Peer, &e.Score, &e.CreateData)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning room records", "err", err)
+			return err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if !opened {
+		last := events[len(events)-1].CreateData
+		events = append(events, RoomEvent{Type: EventClose, Openid: owner, CreateData: last})
+	}
+	// 迁移 0007 时 all_room_events 视图还不存在，不能用 appendEvent 回放，
+	// 在内存中应用事件并按间隔保存快照
+	state := RoomState{RoomId: roomId, Players: []PlayerState{}}
+	for i, e := range events {
+		e.Seq = i + 1
+		err = insertEvent(tx, roomId, e)
+		if err != nil {
+			return err
+		}
+		err = state.apply(e)
+		if err != nil {
+			return err
+		}
+		if e.Seq%snapshotInterval == 0 {
+			err = saveSnapshot(tx, state)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 从事件日志重建房间：旧房间先补写事件，重新生成快照，
+// 再用回放结果改写 scores，返回被改写的分数
+func RebuildRoom(roomId int) ([]ScoreDrift, error) {
+	defer timeQuery("RebuildRoom")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	// 归档房间的数据已不在在线表中，不重建
+	archived, err := isArchived(tx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	if archived {
+		err = ErrRoomArchived
+		return nil, err
+	}
+	err = backfillRoomEvents(tx, roomId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("DELETE FROM room_snapshots WHERE roomId =?", roomId)
+	if err != nil {
+		slog.Error("Error clearing room snapshots", "err", err)
+		return nil, err
+	}
+	events, err := queryRoomEvents(tx, roomId, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	state := RoomState{RoomId: roomId, Players: []PlayerState{}}
+	for _, e := range events {
+		err = state.apply(e)
+		if err != nil {
+			return nil, err
+		}
+		if state.Seq%snapshotInterval == 0 {
+			err = saveSnapshot(tx, state)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	scores := map[string]int{}
+	rows, err := tx.Query("SELECT openid, score FROM scores WHERE roomId =?", roomId)
+	if err != nil {
+		slog.Error("Error querying room scores", "err", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var openid string
+		var score int
+		err = rows.Scan(&openid, &score)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning room scores", "err", err)
+			return nil, err
+		}
+		scores[openid] = score
+	}
+	rows.Close()
+	drifts := []ScoreDrift{}
+	for _, p := range state.Players {
+		score, ok := scores[p.Openid]
+		if ok && score == p.Score {
+			continue
+		}
+		drifts = append(drifts, ScoreDrift{RoomId: roomId, Openid: p.Openid, Score: score, Expected: p.Score, Missing: !ok})
+		if !ok {
+			_, err = tx.Exec("INSERT INTO scores (openid, roomId, score, createData) VALUES (?,?,?, NOW())", p.Openid, roomId, p.Score)
+		} else {
+			_, err = tx.Exec("UPDATE scores SET score =? WHERE openid =? AND roomId =?", p.Score, p.Openid, roomId)
+		}
+		if err != nil {
+			slog.Error("Error rewriting room score", "err", err)
+			return nil, err
+		}
+	}
+	return drifts, nil
+}
+
+// 查询所有未归档房间 id
+func QueryRoomIds() ([]int, error) {
+	defer timeQuery("QueryRoomIds")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT id FROM rooms WHERE id NOT IN (SELECT roomId FROM archived_rooms) ORDER BY id")
+	if err != nil {
+		slog.Error("Error querying rooms", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var roomIds []int
+	for rows.Next() {
+		var roomId int
+		err = rows.Scan(&roomId)
+		if err != nil {
+			slog.Error("Error scanning rooms", "err", err)
+			return nil, err
+		}
+		roomIds = append(roomIds, roomId)
+	}
+	return roomIds, nil
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// 事件日志上线前已有的房间在迁移时补写完整的事件，记录超过快照间隔时同时保存快照
+func TestMigrationBackfillsRoomEvents(t *testing.T) {
+	conn, err := openSqlite(filepath.Join(t.TempDir(), "scoring.db"))
+	must(t, err)
+	setStorage(t, conn, sqliteDialect, &sqlRepository{db: conn})
+	must(t, MigrateTo(6))
+	for _, stmt := range []string{
+		"INSERT INTO users (openid, nickname, roomId, createData) VALUES ('a', 'A', 1, '2024-01-01 10:00:00'), ('b', 'B', 1, '2024-01-01 10:00:00')",
+		"INSERT INTO rooms (owner, createData, opened) VALUES ('a', '2024-01-01 10:00:00', 1)",
+		"INSERT INTO scores (openid, roomId, score, createData) VALUES ('a', 1, 25, '2024-01-01 10:00:00'), ('b', 1, -25, '2024-01-01 10:01:00')",
+	} {
+		_, err = conn.Exec(stmt)
+		must(t, err)
+	}
+	records := snapshotInterval + 5
+	for i := 0; i < records; i++ {
+		_, err = conn.Exec("INSERT INTO records (roomId, score, fromUser, toUser, createData) VALUES (1, 1, 'b', 'a', '2024-01-01 10:02:00')")
+		must(t, err)
+	}
+	must(t, Migrate())
+
+	events, err := GetRoomEvents(1, 0)
+	must(t, err)
+	if len(events) != records+2 {
+		t.Fatalf("got %d events, want %d", len(events), records+2)
+	}
+	if events[0].Type != EventCreate || events[1].Type != EventJoin || events[2].Type != EventRecord || events[len(events)-1].Type != EventRecord {
+		t.Fatalf("got events %+v", events)
+	}
+	var snapshots int
+	must(t, conn.QueryRow("SELECT COUNT(*) FROM room_snapshots WHERE roomId = 1 AND seq =?", snapshotInterval).Scan(&snapshots))
+	if snapshots != 1 {
+		t.Errorf("got %d snapshots at seq %d, want 1", snapshots, snapshotInterval)
+	}
+	state, err := GetRoomState(1, 0)
+	must(t, err)
+	if state.Records != records || len(state.Players) != 2 || state.Players[0].Score != records {
+		t.Errorf("got state %+v", state)
+	}
+	// 之后的计分接在补写的事件后，重建不会丢失旧记录
+	must(t, AddRecord(1, "a", "b", 2))
+	changed, err := RebuildRoom(1)
+	must(t, err)
+	if len(changed) != 0 {
+		t.Errorf("rebuild changed scores %v", changed)
+	}
+	scores := roomScores(t, 1)
+	if scores["a"] != records-2 || scores["b"] != 2-records {
+		t.Errorf("got scores %v", scores)
+	}
+}
+
+func TestVoidedRecordIsNotBiggest(t *testing.T) {
+	useSqlite(t)
+	must(t, RegisterUser("a", "A"))
+	must(t, RegisterUser("b", "B"))
+	roomId, err := CreateRoom("a", DefaultGameType)
+	must(t, err)
+	must(t, JoinRoom("b", roomId))
+	must(t, AddRecord(roomId, "b", "a", 3))
+	must(t, AddRecord(roomId, "b", "a", 50))
+	biggest, err := GetBiggestRecord(roomId)
+	must(t, err)
+	must(t, VoidRecord("a", roomId, biggest.Id))
+
+	// 冲正记录 a 付给 b 50 分同样不计入
+	biggest, err = GetBiggestRecord(roomId)
+	must(t, err)
+	if biggest.Score != 3 {
+		t.Errorf("got biggest record %+v, want the 3 point record", biggest)
+	}
+	for openid, want := range map[string]int{"a": 3, "b": 0} {
+		tx, err := db.Begin()
+		must(t, err)
+		stats, err := queryAchievementStats(tx, openid)
+		tx.Rollback()
+		must(t, err)
+		if stats.BiggestHand != want {
+			t.Errorf("%s biggest hand = %d, want %d", openid, stats.BiggestHand, want)
+		}
+	}
+}
+
+// 作废的记录和冲正记录不出现在记录列表、图表和导出使用的查询中
+func TestVoidedRecordsAreHidden(t *testing.T) {
+	useSqlite(t)
+	roomId := roomWithRecord(t)
+	must(t, AddRecord(roomId, "a", "b", 50))
+	rows, err := GetRoomRecordRows(roomId)
+	must(t, err)
+	must(t, VoidRecord("a", roomId, rows[1].Id))
+
+	records, err := GetRoomRecords(roomId)
+	must(t, err)
+	if len(records) != 1 || records[0].Score != 5 {
+		t.Errorf("got records %+v", records)
+	}
+	rows, err = GetRoomRecordRows(roomId)
+	must(t, err)
+	if len(rows) != 1 || rows[0].Score != 5 {
+		t.Errorf("got record rows %+v", rows)
+	}
+	deltas, err := GetPlayerRecordDeltas("a")
+	must(t, err)
+	if len(deltas) != 1 || deltas[0].Delta != 5 {
+		t.Errorf("got deltas %+v", deltas)
+	}
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+var (
+	// 同一个幂等键用于不同的请求
+	ErrIdempotencyMismatch = errors.New("Idempotency-Key was already used with a different request")
+	// 同一个幂等键的首次请求尚未完成
+	ErrIdempotencyInProgress = errors.New("a request with this Idempotency-Key is still in progress")
+)
+
+// 首次请求的响应
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// 登记幂等键。首次出现时占用该键并返回 nil，由调用方处理请求后调用 FinishIdempotent；
+// 窗口期内重复出现时返回首次请求的响应。fingerprint 为请求内容的摘要
+func BeginIdempotent(openid string, key string, fingerprint string, window time.Duration) (*IdempotentResponse, error) {
+	defer timeQuery("BeginIdempotent")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	// 清理该用户过期的键，过期后可以重新使用同一个键；其他用户的过期键由 PurgeIdempotencyKeys 定期清理
+	expired := time.Now().Add(-window).Format(timeLayout)
+	_, err = db.Exec("DELETE FROM idempotency_keys WHERE openid =? AND createData <?", openid, expired)
+	if err != nil {
+		slog.Error("Error deleting expired idempotency keys", "err", err)
+		return nil, err
+	}
+	// status 为 0 表示处理中
+	result, err := db.Exec(dialect.insertIgnore()+" INTO idempotency_keys (openid, idempotencyKey, fingerprint, status, contentType, createData) VALUES (?,?,?,0,'', NOW())", openid, key, fingerprint)
+	if err != nil {
+		slog.Error("Error inserting idempotency key", "err", err)
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		slog.Error("Error inserting idempotency key", "err", err)
+		return nil, err
+	}
+	if affected == 1 {
+		return nil, nil
+	}
+	var stored string
+	response := IdempotentResponse{}
+	err = db.QueryRow("SELECT fingerprint, status, contentType, body FROM idempotency_keys WHERE openid =? AND idempotencyKey =?", openid, key).Scan(&stored, &response.Status, &response.ContentType, &response.Body)
+	if err != nil {
+		slog.Error("Error querying idempotency key", "err", err)
+		return nil, err
+	}
+	if stored != fingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+	if response.Status == 0 {
+		return nil, ErrIdempotencyInProgress
+	}
+	return &response, nil
+}
+
+// 删除 before 之前登记的幂等键，返回删除的数量
+func PurgeIdempotencyKeys(before time.Time) (int64, error) {
+	defer timeQuery("PurgeIdempotencyKeys")()
+	err := sqlOnly()
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.Exec("DELETE FROM idempotency_keys WHERE createData <?", before.Format(timeLayout))
+	if err != nil {
+		slog.Error("Error purging idempotency keys", "err", err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 保存首次请求的响应
+func FinishIdempotent(openid string, key string, response IdempotentResponse) error {
+	defer timeQuery("FinishIdempotent")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE idempotency_keys SET status =?, contentType =?, body =? WHERE openid =? AND idempotencyKey =?", response.Status, response.ContentType, response.Body, openid, key)
+	if err != nil {
+		slog.Error("Error saving idempotent response", "err", err)
+	}
+	return err
+}
+
+// 释放幂等键，首次请求失败时允许客户端用同一个键重试
+func AbortIdempotent(openid string, key string) error {
+	defer timeQuery("AbortIdempotent")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM idempotency_keys WHERE openid =? AND idempotencyKey =?", openid, key)
+	if err != nil {
+		slog.Error("Error deleting idempotency key", "err", err)
+	}
+	return err
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"scoringMP/service/importer"
+)
+
+// 导入时玩家名的匹配结果
+const (
+	ImportExisting = "existing"
+	ImportGuest    = "guest"
+	ImportNewGuest = "new guest"
+)
+
+type ImportUser struct {
+	Openid string
+	Status string
+}
+
+// 玩家名对应的访客 openid，同名访客在多次导入间复用
+func guestOpenid(name string) string {
+	sum := sha1.Sum([]byte(name))
+	return "guest_" + hex.EncodeToString(sum[:12])
+}
+
+// 把玩家名匹配到昵称唯一的已有用户，匹配不到的作为访客
+func ResolveImportNames(names []string) (map[string]ImportUser, error) {
+	defer timeQuery("ResolveImportNames")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]ImportUser)
+	for _, name := range names {
+		if _, ok := users[name]; ok {
+			continue
+		}
+		guest := guestOpenid(name)
+		var openids []string
+		rows, err := db.Query("SELECT openid FROM users WHERE nickname =? AND openid <>?", name, guest)
+		if err != nil {
+			slog.Error("Error querying users", "err", err)
+			return nil, err
+		}
+		for rows.Next() {
+			var openid string
+			err = rows.Scan(&openid)
+			if err != nil {
+				rows.Close()
+				slog.Error("Error scanning users", "err", err)
+				return nil, err
+			}
+			openids = append(openids, openid)
+		}
+		rows.Close()
+		switch len(openids) {
+		case 0:
+			var count int
+			err = db.QueryRow("SELECT COUNT(*) FROM users WHERE openid =?", guest).Scan(&count)
+			if err != nil {
+				slog.Error("Error querying guest", "err", err)
+				return nil, err
+			}
+			status := ImportNewGuest
+			if count > 0 {
+				status = ImportGuest
+			}
+			users[name] = ImportUser{Openid: guest, Status: status}
+		case 1:
+			users[name] = ImportUser{Openid: openids[0], Status: ImportExisting}
+		default:
+			return nil, fmt.Errorf("nickname %s matches %d users", name, len(openids))
+		}
+	}
+	return users, nil
+}
+
+// 在一个事务内把对局导入为已关闭的房间，导入后重算等级分
+func ImportSessions(owner string, gameType string, sessions []importer.Session, users map[string]ImportUser) error {
+	defer timeQuery("ImportSessions")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	for name, user := range users {
+		if user.Status != ImportNewGuest {
+			continue
+		}
+		_, err = tx.Exec("INSERT INTO users (openid, nickname, createData) VALUES (?, ?, NOW())", user.Openid, name)
+		if err != nil {
+			slog.Error("Error inserting guest", "err", err)
+			return err
+		}
+	}
+	for _, session := range sessions {
+		date := session.Date.Format(timeLayout)
+		var result sql.Result
+		result, err = tx.Exec("INSERT INTO rooms (owner, createData, opened) VALUES (?, ?, 0)", owner, date)
+		if err != nil {
+			slog.Error("Error inserting room", "err", err)
+			return err
+		}
+		var id int64
+		id, err = result.LastInsertId()
+		if err != nil {
+			slog.Error("Error getting room id", "err", err)
+			return err
+		}
+		roomId := int(id)
+		_, err = tx.Exec("INSERT INTO room_settings (roomId, gameType) VALUES (?,?)", roomId, gameType)
+		if err != nil {
+			slog.Error("Error inserting room settings", "err", err)
+			return err
+		}
+		for _, name := range session.Players {
+			_, err = tx.Exec("INSERT INTO scores (openid, roomId, score, createData) VALUES (?,?,?,?)", users[name].Openid, roomId, session.Results[name], date)
+			if err != nil {
+				slog.Error("Error inserting score", "err", err)
+				return err
+			}
+		}
+		for _, t := range session.Transfers {
+			_, err = tx.Exec("INSERT INTO records (roomId, score, fromUser, toUser, createData) VALUES (?,?,?,?,?)", roomId, t.Score, users[t.From].Openid, users[t.To].Openid, date)
+			if err != nil {
+				slog.Error("Error inserting record", "err", err)
+				return err
+			}
+			err = evaluateRecordAchievements(tx, users[t.To].Openid, t.Score, date)
+			if err != nil {
+				return err
+			}
+		}
+		// 根据写入的分数和记录生成房间事件
+		err = backfillRoomEvents(tx, roomId)
+		if err != nil {
+			return err
+		}
+		err = rollupRoom(tx, roomId)
+		if err != nil {
+			return err
+		}
+		err = evaluateRoomAchievements(tx, roomId, date)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		slog.Error("Error committing import", "err", err)
+		return err
+	}
+	// 导入的对局可能早于已有对局，按时间顺序重算等级分
+	return RecomputeRatings()
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// 排行榜统计周期
+const (
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+	PeriodAll   = "all"
+)
+
+// 排行榜排序方式
+const (
+	SortNet      = "net"
+	SortWinRate  = "winRate"
+	SortSessions = "sessions"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+// 根据时间计算各周期的 key
+func periodKeys(t time.Time) map[string]string {
+	year, week := t.ISOWeek()
+	return map[string]string{
+		PeriodWeek:  fmt.Sprintf("%d-W%02d", year, week),
+		PeriodMonth: t.Format("2006-01"),
+		PeriodAll:   PeriodAll,
+	}
+}
+
+// 当前时间所在周期的 key
+func CurrentPeriodKey(period string) (string, error) {
+	key, ok := periodKeys(time.Now())[period]
+	if !ok {
+		return "", errors.New("invalid period")
+	}
+	return key, nil
+}
+
+// 房间关闭时汇总该房间成绩到排行榜
+func rollupRoom(tx *sql.Tx, roomId int) error {
+	var createData string
+	err := tx.QueryRow("SELECT createData FROM rooms WHERE id =?", roomId).Scan(&createData)
+	if err != nil {
+		slog.Error("Error querying room", "err", err)
+		return err
+	}
+	createTime, err := time.ParseInLocation(timeLayout, createData, time.Local)
+	if err != nil {
+		slog.Error("Error parsing room createData", "err", err)
+		return err
+	}
+	rows, err := tx.Query("SELECT openid, score FROM all_scores WHERE roomId =?", roomId)
+	if err != nil {
+		slog.Error("Error querying room scores", "err", err)
+		return err
+	}
+	var openids []string
+	var scores []int
+	for rows.Next() {
+		var openid string
+		var score int
+		err = rows.Scan(&openid, &score)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning room scores", "err", err)
+			return err
+		}
+		openids = append(openids, openid)
+		scores = append(scores, score)
+	}
+	rows.Close()
+	for i, openid := range openids {
+		win := 0
+		if scores[i] > 0 {
+			win = 1
+		}
+		for period, key := range periodKeys(createTime) {
+			_, err = tx.Exec(fmt.Sprintf(`
+				INSERT INTO player_rollups (openid, period, periodKey, net, sessions, wins)
+				VALUES (?,?,?,?,1,?)
+				%s net = net + %s, sessions = sessions + 1, wins = wins + %s
+			`, dialect.onConflict("openid, period, periodKey"), dialect.excluded("net"), dialect.excluded("wins")), openid, period, key, scores[i], win)
+			if err != nil {
+				slog.Error("Error updating player rollup", "err", err)
+				return err
+			}
+		}
+		// 记录同房间玩过的好友
+		for _, peer := range openids {
+			if peer == openid {
+				continue
+			}
+			_, err = tx.Exec(dialect.insertIgnore()+" INTO player_peers (openid, peer) VALUES (?,?)", openid, peer)
+			if err != nil {
+				slog.Error("Error inserting player peer", "err", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 根据已关闭房间重建排行榜汇总
+func RebuildRollups() error {
+	defer timeQuery("RebuildRollups")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	_, err = tx.Exec("DELETE FROM player_rollups")
+	if err != nil {
+		slog.Error("Error clearing player rollups", "err", err)
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM player_peers")
+	if err != nil {
+		slog.Error("Error clearing player peers", "err", err)
+		return err
+	}
+	roomIds, err := queryClosedRooms(tx)
+	if err != nil {
+		return err
+	}
+	for _, roomId := range roomIds {
+		err = rollupRoom(tx, roomId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 按创建顺序查询所有已关闭房间
+func queryClosedRooms(tx *sql.Tx) ([]int, error) {
+	rows, err := tx.Query("SELECT id FROM rooms WHERE opened = 0 ORDER BY createData, id")
+	if err != nil {
+		slog.Error("Error querying closed rooms", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	var roomIds []int
+	for rows.Next() {
+		var roomId int
+		err = rows.Scan(&roomId)
+		if err != nil {
+			slog.Error("Error scanning closed rooms", "err", err)
+			return nil, err
+		}
+		roomIds = append(roomIds, roomId)
+	}
+	return roomIds, nil
+}
+
+type LeaderboardItem struct {
+	Openid   string  `json:"openid"`
+	Nickname string  `json:"nickname"`
+	Net      int     `json:"net"`
+	Sessions int     `json:"sessions"`
+	Wins     int     `json:"wins"`
+	WinRate  float64 `json:"winRate"`
+}
+
+// 获取好友圈排行榜（自己及同房间玩过的玩家）
+func GetLeaderboard(openid string, period string, periodKey string, sortBy string) ([]LeaderboardItem, error) {
+	defer timeQuery("GetLeaderboard")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	var orderBy string
+	switch sortBy {
+	case SortNet:
+		orderBy = "r.net DESC, r.sessions DESC"
+	case SortWinRate:
+		orderBy = "r.wins * 1.0 / r.sessions DESC, r.sessions DESC"
+	case SortSessions:
+		orderBy = "r.sessions DESC, r.net DESC"
+	default:
+		return nil, errors.New("invalid sort")
+	}
+	return readReplica(db, func(q queryer) ([]LeaderboardItem, error) {
+		items := []LeaderboardItem{}
+		rows, err := q.Query(`
+			SELECT r.openid, u.nickname, r.net, r.sessions, r.wins
+			FROM player_rollups r
+			JOIN users u ON r.openid = u.openid
+			WHERE r.period =? AND r.periodKey =?
+			AND (r.openid =? OR r.openid IN (SELECT peer FROM player_peers WHERE openid =?))
+			ORDER BY `+orderBy, period, periodKey, openid, openid)
+		if err != nil {
+			slog.Error("Error querying leaderboard", "err", err)
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var item LeaderboardItem
+			err = rows.Scan(&item.Openid, &item.Nickname, &item.Net, &item.Sessions, &item.Wins)
+			if err != nil {
+				slog.Error("Error scanning leaderboard", "err", err)
+				return nil, err
+			}
+			if item.Sessions > 0 {
+				item.WinRate = float64(item.Wins) / float64(item.Sessions)
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	})
+}
@@ -0,0 +1,341 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"scoringMP/model"
+	"scoringMP/service/settle"
+)
+
+// 账本记录来源
+const (
+	LedgerSettlement = "settlement"
+	// 结算转账确认收款后的冲账
+	LedgerSettlementPaid = "settlementPaid"
+	LedgerPayment        = "payment"
+)
+
+// 由 records 汇总房间内各玩家的余额，各条记录一增一减，总和恒为零；
+// scores 可能因历史问题与 records 不一致，不用于结算
+func roomBalances(tx *sql.Tx, roomId int) ([]settle.Balance, error) {
+	rows, err := tx.Query(`
+		SELECT t.openid, SUM(t.score) FROM (
+			SELECT toUser AS openid, score FROM records WHERE roomId =? AND fromUser <> toUser
+			UNION ALL
+			SELECT fromUser AS openid, -score FROM records WHERE roomId =? AND fromUser <> toUser
+		) t
+		GROUP BY t.openid
+	`, roomId, roomId)
+	if err != nil {
+		slog.Error("Error querying room records", "err", err)
+		return nil, err
+	}
+	var balances []settle.Balance
+	for rows.Next() {
+		var b settle.Balance
+		err = rows.Scan(&b.Openid, &b.Points)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning room balances", "err", err)
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+	rows.Close()
+	return balances, nil
+}
+
+// 房间关闭时保存结算转账并记为欠款。结算失败只记录日志，不影响关闭房间
+func ledgerRoom(tx *sql.Tx, roomId int) error {
+	balances, err := roomBalances(tx, roomId)
+	if err != nil {
+		return err
+	}
+	transfers, err := settle.Minimize(balances)
+	if err != nil {
+		slog.Error("Error settling room, skipping ledger", "roomId", roomId, "err", err)
+		return nil
+	}
+	for _, t := range transfers {
+		_, err = tx.Exec(`
+			INSERT INTO settlements (roomId, fromUser, toUser, points, status, remindCount, createData)
+			VALUES (?,?,?,?,?,0, NOW())
+		`, roomId, t.From, t.To, t.Points, SettlementUnpaid)
+		if err != nil {
+			slog.Error("Error inserting settlement", "err", err)
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO ledger (debtor, creditor, points, source, roomId, createData) VALUES (?,?,?,?,?, NOW())", t.From, t.To, t.Points, LedgerSettlement, roomId)
+		if err != nil {
+			slog.Error("Error inserting ledger", "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+type LedgerBalance struct {
+	Openid   string `json:"openid"`
+	Nickname string `json:"nickname"`
+	// 正数表示对方欠自己，负数表示自己欠对方
+	Points int `json:"points"`
+}
+
+// 获取用户与每位玩家之间的累计欠款
+func GetLedgerBalances(openid string) ([]LedgerBalance, error) {
+	defer timeQuery("GetLedgerBalances")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	balances := []LedgerBalance{}
+	rows, err := db.Query(`
+		SELECT t.other, u.nickname, SUM(t.points) AS total
+		FROM (
+			SELECT creditor AS other, -points AS points FROM ledger WHERE debtor =?
+			UNION ALL
+			SELECT debtor AS other, points FROM ledger WHERE creditor =?
+		) t
+		JOIN users u ON t.other = u.openid
+		GROUP BY t.other, u.nickname
+		HAVING total <> 0
+		ORDER BY total
+	`, openid, openid)
+	if err != nil {
+		slog.Error("Error querying ledger balances", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b LedgerBalance
+		err = rows.Scan(&b.Openid, &b.Nickname, &b.Points)
+		if err != nil {
+			slog.Error("Error scanning ledger balances", "err", err)
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+	return balances, nil
+}
+
+// 付款方还有未确认的结算转账欠收款方时，还款只能冲减这些房间以外结转的欠款。
+// 结算转账确认后会在账本中冲账，同一笔钱再登记还款会重复冲减欠款
+func checkOpenSettlement(q queryer, payer string, payee string, points int) error {
+	var open int
+	err := q.QueryRow("SELECT COALESCE(SUM(points), 0) FROM settlements WHERE fromUser =? AND toUser =? AND status <>?", payer, payee, SettlementConfirmed).Scan(&open)
+	if err != nil {
+		slog.Error("Error querying open settlements", "err", err)
+		return err
+	}
+	if open == 0 {
+		return nil
+	}
+	var owed int
+	err = q.QueryRow(`
+		SELECT COALESCE(SUM(CASE WHEN debtor =? THEN points ELSE -points END), 0) FROM ledger
+		WHERE (debtor =? AND creditor =?) OR (debtor =? AND creditor =?)
+	`, payer, payer, payee, payee, payer).Scan(&owed)
+	if err != nil {
+		slog.Error("Error querying ledger balance", "err", err)
+		return err
+	}
+	if points > owed-open {
+		return errors.New("payment exceeds the debt carried over from earlier rooms, confirm the open room settlement instead")
+	}
+	return nil
+}
+
+// 登记一笔线下还款，发起方视为已确认
+func CreatePayment(openid string, payer string, payee string, points int) (int, error) {
+	defer timeQuery("CreatePayment")()
+	err := sqlOnly()
+	if err != nil {
+		return 0, err
+	}
+	if openid != payer && openid != payee {
+		return 0, errors.New("user is not payer or payee")
+	}
+	if payer == payee {
+		return 0, errors.New("payer and payee are the same")
+	}
+	if points <= 0 {
+		return 0, errors.New("points must be positive")
+	}
+	err = checkOpenSettlement(db, payer, payee, points)
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.Exec(`
+		INSERT INTO ledger_payments (payer, payee, points, payerConfirmed, payeeConfirmed, createData)
+		VALUES (?,?,?,?,?, NOW())
+	`, payer, payee, points, openid == payer, openid == payee)
+	if err != nil {
+		slog.Error("Error inserting payment", "err", err)
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		slog.Error("Error getting payment id", "err", err)
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// 查询还款并加锁
+func queryPaymentForUpdate(tx *sql.Tx, id int) (model.LedgerPayment, error) {
+	var p model.LedgerPayment
+	err := tx.QueryRow("SELECT * FROM ledger_payments WHERE id =?"+dialect.forUpdate(), id).Scan(&p.Id, &p.Payer, &p.Payee, &p.Points, &p.PayerConfirmed, &p.PayeeConfirmed, &p.CreateData, &p.ConfirmData)
+	if err != nil {
+		slog.Error("Error querying payment", "err", err)
+	}
+	return p, err
+}
+
+// 查询还款
+func GetPayment(id int) (model.LedgerPayment, error) {
+	defer timeQuery("GetPayment")()
+	var p model.LedgerPayment
+	err := sqlOnly()
+	if err != nil {
+		return p, err
+	}
+	err = db.QueryRow("SELECT * FROM ledger_payments WHERE id =?", id).Scan(&p.Id, &p.Payer, &p.Payee, &p.Points, &p.PayerConfirmed, &p.PayeeConfirmed, &p.CreateData, &p.ConfirmData)
+	if err != nil {
+		slog.Error("Error querying payment", "err", err)
+	}
+	return p, err
+}
+
+// 确认还款，双方都确认后冲减欠款
+func ConfirmPayment(openid string, id int) error {
+	defer timeQuery("ConfirmPayment")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	p, err := queryPaymentForUpdate(tx, id)
+	if err != nil {
+		return err
+	}
+	if openid != p.Payer && openid != p.Payee {
+		err = errors.New("user is not payer or payee")
+		return err
+	}
+	// 重复确认视为成功
+	if p.PayerConfirmed && p.PayeeConfirmed {
+		return nil
+	}
+	switch openid {
+	case p.Payer:
+		p.PayerConfirmed = true
+	case p.Payee:
+		p.PayeeConfirmed = true
+	}
+	_, err = tx.Exec("UPDATE ledger_payments SET payerConfirmed =?, payeeConfirmed =? WHERE id =?", p.PayerConfirmed, p.PayeeConfirmed, id)
+	if err != nil {
+		slog.Error("Error updating payment", "err", err)
+		return err
+	}
+	if !p.PayerConfirmed || !p.PayeeConfirmed {
+		return nil
+	}
+	// 登记后两人新产生的结算转账同样以结算确认为准
+	err = checkOpenSettlement(tx, p.Payer, p.Payee, p.Points)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE ledger_payments SET confirmData = NOW() WHERE id =?", id)
+	if err != nil {
+		slog.Error("Error updating payment", "err", err)
+		return err
+	}
+	// 付款方付钱相当于收款方欠付款方同样的分数
+	_, err = tx.Exec("INSERT INTO ledger (debtor, creditor, points, source, paymentId, createData) VALUES (?,?,?,?,?, NOW())", p.Payee, p.Payer, p.Points, LedgerPayment, id)
+	if err != nil {
+		slog.Error("Error inserting ledger", "err", err)
+		return err
+	}
+	return nil
+}
+
+// 拒绝或撤销尚未双方确认的还款
+func CancelPayment(openid string, id int) error {
+	defer timeQuery("CancelPayment")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	p, err := queryPaymentForUpdate(tx, id)
+	if err != nil {
+		return err
+	}
+	if openid != p.Payer && openid != p.Payee {
+		err = errors.New("user is not payer or payee")
+		return err
+	}
+	if p.PayerConfirmed && p.PayeeConfirmed {
+		err = errors.New("payment is already confirmed")
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM ledger_payments WHERE id =?", id)
+	if err != nil {
+		slog.Error("Error deleting payment", "err", err)
+		return err
+	}
+	return nil
+}
+
+// 获取用户待确认的还款
+func GetPendingPayments(openid string) ([]model.LedgerPayment, error) {
+	defer timeQuery("GetPendingPayments")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	payments := []model.LedgerPayment{}
+	rows, err := db.Query(`
+		SELECT * FROM ledger_payments
+		WHERE (payer =? OR payee =?) AND NOT (payerConfirmed AND payeeConfirmed)
+		ORDER BY createData DESC
+	`, openid, openid)
+	if err != nil {
+		slog.Error("Error querying payments", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p model.LedgerPayment
+		err = rows.Scan(&p.Id, &p.Payer, &p.Payee, &p.Points, &p.PayerConfirmed, &p.PayeeConfirmed, &p.CreateData, &p.ConfirmData)
+		if err != nil {
+			slog.Error("Error scanning payments", "err", err)
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, nil
+}
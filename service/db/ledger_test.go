@@ -0,0 +1,74 @@
+package db
+
+import "testing"
+
+func ledgerBalance(t *testing.T, openid string, other string) int {
+	t.Helper()
+	balances, err := GetLedgerBalances(openid)
+	must(t, err)
+	for _, b := range balances {
+		if b.Openid == other {
+			return b.Points
+		}
+	}
+	return 0
+}
+
+// scores 与 records 不一致时仍可关闭房间，结算以 records 为准
+func TestCloseRoomWithDriftedScores(t *testing.T) {
+	useSqlite(t)
+	roomId := roomWithRecord(t)
+	_, err := db.Exec("UPDATE scores SET score = score + 7 WHERE openid = 'a' AND roomId =?", roomId)
+	must(t, err)
+	closed, err := QuitRoom("a", roomId)
+	must(t, err)
+	if !closed {
+		t.Fatal("room was not closed")
+	}
+	settlements, err := GetRoomSettlements(roomId)
+	must(t, err)
+	if len(settlements) != 1 || settlements[0].FromUser != "b" || settlements[0].ToUser != "a" || settlements[0].Points != 5 {
+		t.Errorf("got settlements %+v", settlements)
+	}
+}
+
+// 结算转账未确认时不能再通过还款冲减同一笔欠款，之前结转的欠款仍可还
+func TestPaymentWhileSettlementOpen(t *testing.T) {
+	useSqlite(t)
+	roomId := roomWithRecord(t)
+	// 结算转账上线前关闭的房间留下的欠款，没有对应的结算转账
+	_, err := db.Exec("INSERT INTO ledger (debtor, creditor, points, source, createData) VALUES ('b', 'a', 10, ?, NOW())", LedgerSettlement)
+	must(t, err)
+	_, err = QuitRoom("a", roomId)
+	must(t, err)
+	if got := ledgerBalance(t, "a", "b"); got != 15 {
+		t.Fatalf("a's balance with b = %d, want 15", got)
+	}
+	_, err = CreatePayment("b", "b", "a", 11)
+	if err == nil {
+		t.Fatal("payment covering the open settlement was accepted")
+	}
+	id, err := CreatePayment("b", "b", "a", 10)
+	must(t, err)
+	must(t, ConfirmPayment("a", id))
+	// 重复确认视为成功，不会重复冲账
+	must(t, ConfirmPayment("a", id))
+	must(t, ConfirmPayment("b", id))
+	if got := ledgerBalance(t, "a", "b"); got != 5 {
+		t.Errorf("a's balance with b after payment = %d, want 5", got)
+	}
+
+	settlements, err := GetRoomSettlements(roomId)
+	must(t, err)
+	must(t, ConfirmSettlement("a", settlements[0].Id))
+	if got := ledgerBalance(t, "a", "b"); got != 0 {
+		t.Errorf("a's balance with b after settlement = %d, want 0", got)
+	}
+	// 结算确认后可以登记新的还款
+	id, err = CreatePayment("b", "b", "a", 2)
+	must(t, err)
+	must(t, ConfirmPayment("a", id))
+	if got := ledgerBalance(t, "a", "b"); got != -2 {
+		t.Errorf("a's balance with b after payment = %d, want -2", got)
+	}
+}
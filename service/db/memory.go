@@ -0,0 +1,294 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"scoringMP/model"
+	"sync"
+	"time"
+)
+
+// 内存存储，语义与 SQL 实现一致，只保存用户、房间、分数、记录，
+// 排行榜、等级分、账本等功能表不可用
+type memoryRepository struct {
+	mu        sync.Mutex
+	users     map[string]*model.User
+	rooms     []*model.Room
+	gameTypes map[int]string
+	scores    []*model.Score
+	records   []*model.Record
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{
+		users:     make(map[string]*model.User),
+		gameTypes: make(map[int]string),
+	}
+}
+
+func now() string {
+	return time.Now().Format(timeLayout)
+}
+
+// 以下方法均需持有锁调用
+func (m *memoryRepository) room(roomId int) (*model.Room, error) {
+	if roomId < 1 || roomId > len(m.rooms) {
+		return nil, sql.ErrNoRows
+	}
+	return m.rooms[roomId-1], nil
+}
+
+func (m *memoryRepository) score(openid string, roomId int) (*model.Score, error) {
+	for _, s := range m.scores {
+		if s.Openid == openid && s.RoomId == roomId {
+			return s, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *memoryRepository) nickname(openid string) string {
+	if user, ok := m.users[openid]; ok {
+		return user.Nickname
+	}
+	return ""
+}
+
+func (m *memoryRepository) QueryUser(openid string) (model.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[openid]
+	if !ok {
+		return model.User{}, sql.ErrNoRows
+	}
+	return *user, nil
+}
+
+func (m *memoryRepository) RegisterUser(openid string, nickname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[openid]; ok {
+		return errors.New("user already exists")
+	}
+	m.users[openid] = &model.User{Openid: openid, Nickname: nickname, CreateData: now()}
+	return nil
+}
+
+func (m *memoryRepository) UpdateNickname(openid string, nickname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if user, ok := m.users[openid]; ok {
+		user.Nickname = nickname
+	}
+	return nil
+}
+
+func (m *memoryRepository) QueryUserRoom(openid string) (model.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[openid]
+	if !ok || !user.RoomId.Valid {
+		return model.Room{}, sql.ErrNoRows
+	}
+	room, err := m.room(int(user.RoomId.Int64))
+	if err != nil || !room.Opened {
+		return model.Room{}, sql.ErrNoRows
+	}
+	return *room, nil
+}
+
+func (m *memoryRepository) QueryHistory(openid string) ([]HistoryItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	scores := []HistoryItem{}
+	for i := len(m.scores) - 1; i >= 0; i-- {
+		s := m.scores[i]
+		if s.Openid != openid {
+			continue
+		}
+		room, err := m.room(s.RoomId)
+		if err != nil {
+			return nil, err
+		}
+		// 内存存储不保存结算，房间关闭即视为已结清
+		scores = append(scores, HistoryItem{RoomId: s.RoomId, Score: s.Score, CreateData: s.CreateData, Opened: room.Opened, Cleared: !room.Opened})
+	}
+	return scores, nil
+}
+
+func (m *memoryRepository) CreateRoom(openid string, gameType string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[openid]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	if user.RoomId.Valid {
+		return int(user.RoomId.Int64), nil
+	}
+	roomId := len(m.rooms) + 1
+	m.rooms = append(m.rooms, &model.Room{Id: roomId, Owner: openid, CreateData: now(), Opened: true})
+	m.gameTypes[roomId] = gameType
+	user.RoomId = sql.NullInt64{Int64: int64(roomId), Valid: true}
+	m.scores = append(m.scores, &model.Score{Id: len(m.scores) + 1, Openid: openid, RoomId: roomId, CreateData: now()})
+	return roomId, nil
+}
+
+func (m *memoryRepository) JoinRoom(openid string, roomId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	room, err := m.room(roomId)
+	if err != nil {
+		return err
+	}
+	if !room.Opened {
+		return errors.New("room is closed")
+	}
+	user, ok := m.users[openid]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if user.RoomId.Valid && user.RoomId.Int64 == int64(roomId) {
+		return errors.New("user already in room")
+	}
+	user.RoomId = sql.NullInt64{Int64: int64(roomId), Valid: true}
+	if _, err := m.score(openid, roomId); err == nil {
+		return nil
+	}
+	m.scores = append(m.scores, &model.Score{Id: len(m.scores) + 1, Openid: openid, RoomId: roomId, CreateData: now()})
+	return nil
+}
+
+func (m *memoryRepository) QuitRoom(openid string, roomId int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[openid]
+	if !ok || !user.RoomId.Valid || user.RoomId.Int64 != int64(roomId) {
+		return false, errors.New("user not in room")
+	}
+	room, err := m.room(roomId)
+	if err != nil || !room.Opened {
+		return false, sql.ErrNoRows
+	}
+	if room.Owner != openid {
+		user.RoomId = sql.NullInt64{}
+		return false, nil
+	}
+	for _, u := range m.users {
+		if u.RoomId.Valid && u.RoomId.Int64 == int64(roomId) {
+			u.RoomId = sql.NullInt64{}
+		}
+	}
+	room.Opened = false
+	return true, nil
+}
+
+func (m *memoryRepository) QueryRoom(roomId int) (model.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	room, err := m.room(roomId)
+	if err != nil {
+		return model.Room{}, err
+	}
+	return *room, nil
+}
+
+func (m *memoryRepository) CheckRoom(roomId int) (bool, error) {
+	room, err := m.QueryRoom(roomId)
+	return room.Opened, err
+}
+
+func (m *memoryRepository) GetRoomGameType(roomId int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gameType, ok := m.gameTypes[roomId]
+	if !ok {
+		return DefaultGameType, nil
+	}
+	return gameType, nil
+}
+
+func (m *memoryRepository) GetRoomUsers(roomId int) ([]UserScore, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var users []UserScore
+	for i := len(m.scores) - 1; i >= 0; i-- {
+		s := m.scores[i]
+		if s.RoomId == roomId {
+			users = append(users, UserScore{Openid: s.Openid, Score: s.Score, Nickname: m.nickname(s.Openid)})
+		}
+	}
+	return users, nil
+}
+
+func (m *memoryRepository) GetRoomScores(roomId int) ([]model.Score, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var scores []model.Score
+	for _, s := range m.scores {
+		if s.RoomId == roomId {
+			scores = append(scores, *s)
+		}
+	}
+	return scores, nil
+}
+
+func (m *memoryRepository) AddRecord(roomId int, fromUser string, toUser string, score int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fromScore, err := m.score(fromUser, roomId)
+	if err != nil {
+		return err
+	}
+	toScore, err := m.score(toUser, roomId)
+	if err != nil {
+		return err
+	}
+	fromScore.Score -= score
+	toScore.Score += score
+	m.records = append(m.records, &model.Record{Id: len(m.records) + 1, RoomId: roomId, Score: score, FromUser: fromUser, ToUser: toUser, CreateData: now()})
+	return nil
+}
+
+func (m *memoryRepository) userRecord(r *model.Record) UserRecord {
+	return UserRecord{Id: r.Id, FromUser: m.nickname(r.FromUser), ToUser: m.nickname(r.ToUser), Score: r.Score, Time: r.CreateData}
+}
+
+func (m *memoryRepository) GetRoomRecords(roomId int) ([]UserRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records []UserRecord
+	for i := len(m.records) - 1; i >= 0; i-- {
+		if m.records[i].RoomId == roomId {
+			records = append(records, m.userRecord(m.records[i]))
+		}
+	}
+	return records, nil
+}
+
+func (m *memoryRepository) GetRoomRecordRows(roomId int) ([]model.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records []model.Record
+	for _, r := range m.records {
+		if r.RoomId == roomId {
+			records = append(records, *r)
+		}
+	}
+	return records, nil
+}
+
+func (m *memoryRepository) GetBiggestRecord(roomId int) (UserRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var biggest *model.Record
+	for _, r := range m.records {
+		if r.RoomId == roomId && (biggest == nil || r.Score > biggest.Score) {
+			biggest = r
+		}
+	}
+	if biggest == nil {
+		return UserRecord{}, sql.ErrNoRows
+	}
+	return m.userRecord(biggest), nil
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"scoringMP/metrics"
+	"time"
+)
+
+var (
+	queryDuration  = metrics.NewHistogramVec("scoring_db_query_duration_seconds", "Latency of service/db calls by function.", metrics.DefBuckets, "function")
+	recordsCreated = metrics.NewCounter("scoring_records_created_total", "Score records created since the process started.")
+)
+
+// 记录函数耗时，用法：defer timeQuery("GetLeaderboard")()
+func timeQuery(function string) func() {
+	start := time.Now()
+	return func() {
+		queryDuration.With(function).Observe(time.Since(start).Seconds())
+	}
+}
+
+// 抓取时从数据库统计的业务指标，内存存储时不输出
+func init() {
+	metrics.NewGaugeFunc("scoring_open_rooms", "Rooms that are currently open.", func() (float64, error) {
+		return countRows("SELECT COUNT(*) FROM rooms WHERE opened = 1")
+	})
+	metrics.NewGaugeFunc("scoring_active_players", "Players currently in an open room.", func() (float64, error) {
+		return countRows("SELECT COUNT(*) FROM users WHERE roomId IS NOT NULL")
+	})
+	metrics.NewGaugeFunc("scoring_records_last_minute", "Score records created in the last minute.", func() (float64, error) {
+		return countRows("SELECT COUNT(*) FROM records WHERE createData >=?", time.Now().Add(-time.Minute).Format(timeLayout))
+	})
+}
+
+func countRows(query string, args ...any) (float64, error) {
+	err := sqlOnly()
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = db.QueryRow(query, args...).Scan(&count)
+	return float64(count), err
+}
@@ -0,0 +1,390 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 各方言的迁移脚本，文件名为 <版本>_<名称>.up.sql / .down.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// MySQL 迁移锁名，防止多个实例同时迁移
+const migrationLock = "scoring_schema_migrations"
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type MigrationState struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+	// 执行时间，未执行时为空
+	AppliedData string `json:"appliedData"`
+}
+
+// 读取当前方言的迁移脚本，按版本排序
+func loadMigrations() ([]Migration, error) {
+	dir := path.Join("migrations", string(dialect))
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		slog.Error("Error reading migrations", "err", err)
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, errors.New("invalid migration file: " + name)
+		}
+		versionText, migrationName, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionText)
+		if err != nil {
+			return nil, errors.New("invalid migration version: " + name)
+		}
+		content, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+	var migrations []Migration
+	for _, m := range byVersion {
+		if len(splitStatements(m.Up)) == 0 {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// 拆分脚本中的语句，忽略 -- 注释行
+func splitStatements(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	var stmts []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// *sql.Conn 与 *sql.Tx 的公共方法
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// 迁移脚本执行后需要在同一迁移中执行的数据处理，按版本索引。
+// 处理函数运行在该版本的表结构上，不能依赖之后的迁移
+var migrationHooks = map[int]func(tx *sql.Tx) error{
+	// 事件日志上线前的房间没有事件，补写后回放和重建才能得到完整的历史
+	7: backfillAllRoomEvents,
+}
+
+// 在事务中执行迁移的数据处理。SQLite 的迁移本身在事务中，MySQL 在迁移连接上开启事务
+func runMigrationHook(ctx context.Context, q execQueryer, hook func(tx *sql.Tx) error) error {
+	if tx, ok := q.(*sql.Tx); ok {
+		return hook(tx)
+	}
+	tx, err := q.(*sql.Conn).BeginTx(ctx, nil)
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	err = hook(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+type appliedMigration struct {
+	checksum    string
+	appliedData string
+}
+
+// 查询已执行的迁移
+func queryAppliedMigrations(ctx context.Context, q execQueryer) (map[int]appliedMigration, error) {
+	_, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum VARCHAR(64) NOT NULL,
+		appliedData VARCHAR(32) NOT NULL
+	)`)
+	if err != nil {
+		slog.Error("Error creating schema_migrations", "err", err)
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, "SELECT version, checksum, appliedData FROM schema_migrations")
+	if err != nil {
+		slog.Error("Error querying schema_migrations", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		err = rows.Scan(&version, &a.checksum, &a.appliedData)
+		if err != nil {
+			slog.Error("Error scanning schema_migrations", "err", err)
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// 校验已执行的迁移与当前脚本一致
+func verifyMigrations(migrations []Migration, applied map[int]appliedMigration) error {
+	known := map[int]bool{}
+	for _, m := range migrations {
+		known[m.Version] = true
+		a, ok := applied[m.Version]
+		if ok && a.checksum != m.Checksum {
+			return fmt.Errorf("migration %d_%s was changed after it was applied (checksum mismatch)", m.Version, m.Name)
+		}
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("database has migration %d which this build does not know, refusing to continue", version)
+		}
+	}
+	return nil
+}
+
+// 在迁移锁内执行 fn。MySQL 的 DDL 不支持事务，使用 GET_LOCK 加锁；
+// SQLite 的 DDL 支持事务，整个迁移在一个立即获取写锁的事务中完成
+func withMigrationLock(fn func(ctx context.Context, q execQueryer) error) error {
+	ctx := context.Background()
+	if dialect == sqliteDialect {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			slog.Error("Error starting transaction", "err", err)
+			return err
+		}
+		err = fn(ctx, tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		slog.Error("Error getting connection", "err", err)
+		return err
+	}
+	defer conn.Close()
+	var locked sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 60)", migrationLock).Scan(&locked)
+	if err != nil {
+		slog.Error("Error acquiring migration lock", "err", err)
+		return err
+	}
+	if locked.Int64 != 1 {
+		return errors.New("timed out waiting for migration lock, another instance may be migrating")
+	}
+	defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLock)
+	return fn(ctx, conn)
+}
+
+// 迁移到最新版本
+func Migrate() error {
+	return MigrateTo(-1)
+}
+
+// 迁移到指定版本，低于当前版本时依次执行 down 脚本，target 为 -1 时迁移到最新版本
+func MigrateTo(target int) error {
+	// 内存存储没有表结构
+	if db == nil {
+		return nil
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if target < 0 {
+		target = migrations[len(migrations)-1].Version
+	}
+	return withMigrationLock(func(ctx context.Context, q execQueryer) error {
+		applied, err := queryAppliedMigrations(ctx, q)
+		if err != nil {
+			return err
+		}
+		err = verifyMigrations(migrations, applied)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok || m.Version > target {
+				continue
+			}
+			slog.Info("Applying migration", "version", m.Version, "name", m.Name)
+			for _, stmt := range splitStatements(m.Up) {
+				_, err = q.ExecContext(ctx, stmt)
+				if err != nil {
+					slog.Error("Error applying migration", "version", m.Version, "name", m.Name, "err", err)
+					return err
+				}
+			}
+			if hook, ok := migrationHooks[m.Version]; ok {
+				err = runMigrationHook(ctx, q, hook)
+				if err != nil {
+					slog.Error("Error applying migration", "version", m.Version, "name", m.Name, "err", err)
+					return err
+				}
+			}
+			_, err = q.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, checksum, appliedData) VALUES (?,?,?,NOW())", m.Version, m.Name, m.Checksum)
+			if err != nil {
+				slog.Error("Error recording migration", "err", err)
+				return err
+			}
+		}
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok || m.Version <= target {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s cannot be reverted", m.Version, m.Name)
+			}
+			slog.Info("Reverting migration", "version", m.Version, "name", m.Name)
+			for _, stmt := range splitStatements(m.Down) {
+				_, err = q.ExecContext(ctx, stmt)
+				if err != nil {
+					slog.Error("Error reverting migration", "version", m.Version, "name", m.Name, "err", err)
+					return err
+				}
+			}
+			_, err = q.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version =?", m.Version)
+			if err != nil {
+				slog.Error("Error deleting migration", "err", err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 查询各迁移的执行状态
+func MigrationStatus() ([]MigrationState, error) {
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := queryAppliedMigrations(context.Background(), db)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyMigrations(migrations, applied)
+	if err != nil {
+		return nil, err
+	}
+	states := []MigrationState{}
+	for _, m := range migrations {
+		a, ok := applied[m.Version]
+		states = append(states, MigrationState{Version: m.Version, Name: m.Name, Applied: ok, AppliedData: a.appliedData})
+	}
+	return states, nil
+}
+
+// 检查数据库已迁移到最新版本，用于手动迁移时启动前的检查
+func CheckMigrations() error {
+	if db == nil {
+		return nil
+	}
+	states, err := MigrationStatus()
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		if !state.Applied {
+			return fmt.Errorf("migration %d_%s is pending, run ./main migrate", state.Version, state.Name)
+		}
+	}
+	return nil
+}
+
+// 本版本最新的迁移版本及迁移个数，InitDB 时计算一次，供就绪检查对比
+var expectedSchema struct {
+	version int
+	count   int
+}
+
+func loadExpectedSchema() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	expectedSchema.count = len(migrations)
+	if len(migrations) > 0 {
+		expectedSchema.version = migrations[len(migrations)-1].Version
+	}
+	return nil
+}
+
+// 就绪检查使用：只读查询已执行的迁移，确认数据库为本版本最新的表结构。
+// 不建表也不校验脚本，完整校验见 CheckMigrations
+func CheckSchemaVersion(ctx context.Context) error {
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	var version, count int
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0), COUNT(*) FROM schema_migrations").Scan(&version, &count)
+	if err != nil {
+		slog.Error("Error querying schema_migrations", "err", err)
+		return err
+	}
+	if version != expectedSchema.version || count != expectedSchema.count {
+		return fmt.Errorf("database has %d migrations up to %d, this build expects %d up to %d", count, version, expectedSchema.count, expectedSchema.version)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"empty", "", nil},
+		{"comments only", "-- 说明\n  -- 缩进的注释\n", nil},
+		{"statements", "CREATE TABLE a (id INT);\n\n-- 注释\nCREATE INDEX i ON a (id);", []string{"CREATE TABLE a (id INT)", "CREATE INDEX i ON a (id)"}},
+		{"multi-line", "CREATE TABLE a (\n    id INT\n);\n;", []string{"CREATE TABLE a (\n    id INT\n)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitStatements(tt.script)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("statement %d: got %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	versions := map[sqlDialect][]int{}
+	for _, d := range []sqlDialect{mysqlDialect, sqliteDialect} {
+		old := dialect
+		dialect = d
+		migrations, err := loadMigrations()
+		dialect = old
+		must(t, err)
+		for _, m := range migrations {
+			if m.Down == "" {
+				t.Errorf("%s migration %d_%s has no down script", d, m.Version, m.Name)
+			}
+			versions[d] = append(versions[d], m.Version)
+		}
+	}
+	if len(versions[mysqlDialect]) != len(versions[sqliteDialect]) {
+		t.Fatalf("mysql has migrations %v, sqlite has %v", versions[mysqlDialect], versions[sqliteDialect])
+	}
+	for i, v := range versions[mysqlDialect] {
+		if versions[sqliteDialect][i] != v {
+			t.Errorf("mysql has migrations %v, sqlite has %v", versions[mysqlDialect], versions[sqliteDialect])
+			break
+		}
+	}
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	useSqlite(t)
+	must(t, loadExpectedSchema())
+	ctx := context.Background()
+	must(t, CheckSchemaVersion(ctx))
+	must(t, MigrateTo(expectedSchema.version-1))
+	if CheckSchemaVersion(ctx) == nil {
+		t.Error("schema one migration behind passed the check")
+	}
+	must(t, Migrate())
+	must(t, CheckSchemaVersion(ctx))
+}
@@ -0,0 +1,38 @@
+-- 初始表结构：用户、房间、分数、记录
+
+CREATE TABLE IF NOT EXISTS users (
+    openid VARCHAR(255) PRIMARY KEY,
+    nickname VARCHAR(255) NOT NULL,
+    roomId INT,
+    createData DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rooms (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    owner VARCHAR(255) NOT NULL,
+    createData DATETIME NOT NULL,
+    opened BOOLEAN NOT NULL,
+    FOREIGN KEY (owner) REFERENCES users(openid)
+);
+
+CREATE TABLE IF NOT EXISTS scores (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    openid VARCHAR(255) NOT NULL,
+    roomId INT NOT NULL,
+    score INT NOT NULL,
+    createData DATETIME NOT NULL,
+    FOREIGN KEY (openid) REFERENCES users(openid),
+    FOREIGN KEY (roomId) REFERENCES rooms(id)
+);
+
+CREATE TABLE IF NOT EXISTS records (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    roomId INT NOT NULL,
+    score INT NOT NULL,
+    fromUser VARCHAR(255) NOT NULL,
+    toUser VARCHAR(255) NOT NULL,
+    createData DATETIME NOT NULL,
+    FOREIGN KEY (roomId) REFERENCES rooms(id),
+    FOREIGN KEY (fromUser) REFERENCES users(openid),
+    FOREIGN KEY (toUser) REFERENCES users(openid)
+);
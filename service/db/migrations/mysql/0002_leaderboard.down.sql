@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS player_peers;
+DROP TABLE IF EXISTS player_rollups;
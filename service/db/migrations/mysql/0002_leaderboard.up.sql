@@ -0,0 +1,21 @@
+-- 好友圈排行榜汇总
+
+CREATE TABLE IF NOT EXISTS player_rollups (
+    openid VARCHAR(255) NOT NULL,
+    period VARCHAR(16) NOT NULL,
+    periodKey VARCHAR(16) NOT NULL,
+    net INT NOT NULL,
+    sessions INT NOT NULL,
+    wins INT NOT NULL,
+    PRIMARY KEY (openid, period, periodKey),
+    INDEX idx_period (period, periodKey),
+    FOREIGN KEY (openid) REFERENCES users(openid)
+);
+
+CREATE TABLE IF NOT EXISTS player_peers (
+    openid VARCHAR(255) NOT NULL,
+    peer VARCHAR(255) NOT NULL,
+    PRIMARY KEY (openid, peer),
+    FOREIGN KEY (openid) REFERENCES users(openid),
+    FOREIGN KEY (peer) REFERENCES users(openid)
+);
@@ -0,0 +1,30 @@
+-- 房间玩法与等级分
+
+CREATE TABLE IF NOT EXISTS room_settings (
+    roomId INT PRIMARY KEY,
+    gameType VARCHAR(64) NOT NULL,
+    FOREIGN KEY (roomId) REFERENCES rooms(id)
+);
+
+CREATE TABLE IF NOT EXISTS ratings (
+    openid VARCHAR(255) NOT NULL,
+    gameType VARCHAR(64) NOT NULL,
+    rating DOUBLE NOT NULL,
+    games INT NOT NULL,
+    PRIMARY KEY (openid, gameType),
+    FOREIGN KEY (openid) REFERENCES users(openid)
+);
+
+CREATE TABLE IF NOT EXISTS rating_history (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    openid VARCHAR(255) NOT NULL,
+    gameType VARCHAR(64) NOT NULL,
+    roomId INT NOT NULL,
+    ratingBefore DOUBLE NOT NULL,
+    ratingAfter DOUBLE NOT NULL,
+    delta DOUBLE NOT NULL,
+    createData DATETIME NOT NULL,
+    INDEX idx_openid_gameType (openid, gameType),
+    FOREIGN KEY (openid) REFERENCES users(openid),
+    FOREIGN KEY (roomId) REFERENCES rooms(id)
+);
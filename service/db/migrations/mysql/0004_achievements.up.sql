@@ -0,0 +1,9 @@
+-- 成就
+
+CREATE TABLE IF NOT EXISTS achievements (
+    openid VARCHAR(255) NOT NULL,
+    achievementId VARCHAR(64) NOT NULL,
+    unlockData DATETIME NOT NULL,
+    PRIMARY KEY (openid, achievementId),
+    FOREIGN KEY (openid) REFERENCES users(openid)
+);
@@ -0,0 +1,31 @@
+-- 跨房间账本与手动还款
+
+CREATE TABLE IF NOT EXISTS ledger_payments (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    payer VARCHAR(255) NOT NULL,
+    payee VARCHAR(255) NOT NULL,
+    points INT NOT NULL,
+    payerConfirmed BOOLEAN NOT NULL,
+    payeeConfirmed BOOLEAN NOT NULL,
+    createData DATETIME NOT NULL,
+    confirmData DATETIME,
+    FOREIGN KEY (payer) REFERENCES users(openid),
+    FOREIGN KEY (payee) REFERENCES users(openid)
+);
+
+CREATE TABLE IF NOT EXISTS ledger (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    debtor VARCHAR(255) NOT NULL,
+    creditor VARCHAR(255) NOT NULL,
+    points INT NOT NULL,
+    source VARCHAR(16) NOT NULL,
+    roomId INT,
+    paymentId INT,
+    createData DATETIME NOT NULL,
+    INDEX idx_debtor (debtor),
+    INDEX idx_creditor (creditor),
+    FOREIGN KEY (debtor) REFERENCES users(openid),
+    FOREIGN KEY (creditor) REFERENCES users(openid),
+    FOREIGN KEY (roomId) REFERENCES rooms(id),
+    FOREIGN KEY (paymentId) REFERENCES ledger_payments(id)
+);
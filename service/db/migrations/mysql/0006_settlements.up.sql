@@ -0,0 +1,18 @@
+-- 房间结算转账与付款状态
+
+CREATE TABLE IF NOT EXISTS settlements (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    roomId INT NOT NULL,
+    fromUser VARCHAR(255) NOT NULL,
+    toUser VARCHAR(255) NOT NULL,
+    points INT NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    remindCount INT NOT NULL,
+    remindData DATETIME,
+    claimData DATETIME,
+    confirmData DATETIME,
+    createData DATETIME NOT NULL,
+    FOREIGN KEY (roomId) REFERENCES rooms(id),
+    FOREIGN KEY (fromUser) REFERENCES users(openid),
+    FOREIGN KEY (toUser) REFERENCES users(openid)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS room_snapshots;
+DROP TABLE IF EXISTS room_events;
@@ -0,0 +1,28 @@
+-- 房间事件日志与投影快照
+
+CREATE TABLE IF NOT EXISTS room_events (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    roomId INT NOT NULL,
+    seq INT NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    openid VARCHAR(255),
+    peer VARCHAR(255),
+    score INT NOT NULL,
+    recordId INT,
+    payload TEXT,
+    createData DATETIME NOT NULL,
+    UNIQUE KEY uk_room_seq (roomId, seq),
+    FOREIGN KEY (roomId) REFERENCES rooms(id),
+    FOREIGN KEY (openid) REFERENCES users(openid),
+    FOREIGN KEY (peer) REFERENCES users(openid),
+    FOREIGN KEY (recordId) REFERENCES records(id)
+);
+
+CREATE TABLE IF NOT EXISTS room_snapshots (
+    roomId INT NOT NULL,
+    seq INT NOT NULL,
+    state TEXT NOT NULL,
+    createData DATETIME NOT NULL,
+    PRIMARY KEY (roomId, seq),
+    FOREIGN KEY (roomId) REFERENCES rooms(id)
+);
@@ -0,0 +1,13 @@
+-- 幂等键与首次响应
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    openid VARCHAR(255) NOT NULL,
+    idempotencyKey VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    status INT NOT NULL,
+    contentType VARCHAR(255) NOT NULL,
+    body MEDIUMBLOB,
+    createData DATETIME NOT NULL,
+    PRIMARY KEY (openid, idempotencyKey),
+    INDEX idx_createData (createData)
+);
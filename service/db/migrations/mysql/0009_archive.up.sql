@@ -0,0 +1,63 @@
+-- 归档已关闭的旧房间：分数、记录、事件移入归档表，房间本身保留，
+-- all_* 视图合并在线表与归档表，供历史战绩和统计查询。
+-- 视图中的 UNION 依赖 MySQL 8.0.29 起的条件下推使用索引。
+-- 在线表的 roomId、openid 已有外键自动创建的索引
+
+CREATE TABLE IF NOT EXISTS archived_rooms (
+    roomId INT PRIMARY KEY,
+    archiveData DATETIME NOT NULL,
+    FOREIGN KEY (roomId) REFERENCES rooms(id)
+);
+
+CREATE TABLE IF NOT EXISTS archived_scores (
+    id INT PRIMARY KEY,
+    openid VARCHAR(255) NOT NULL,
+    roomId INT NOT NULL,
+    score INT NOT NULL,
+    createData DATETIME NOT NULL,
+    INDEX idx_room_openid (roomId, openid),
+    INDEX idx_openid (openid)
+);
+
+CREATE TABLE IF NOT EXISTS archived_records (
+    id INT PRIMARY KEY,
+    roomId INT NOT NULL,
+    score INT NOT NULL,
+    fromUser VARCHAR(255) NOT NULL,
+    toUser VARCHAR(255) NOT NULL,
+    createData DATETIME NOT NULL,
+    INDEX idx_room_createData (roomId, createData),
+    INDEX idx_fromUser (fromUser),
+    INDEX idx_toUser (toUser)
+);
+
+CREATE TABLE IF NOT EXISTS archived_room_events (
+    id INT PRIMARY KEY,
+    roomId INT NOT NULL,
+    seq INT NOT NULL,
+    type VARCHAR(16) NOT NULL,
+    openid VARCHAR(255),
+    peer VARCHAR(255),
+    score INT NOT NULL,
+    recordId INT,
+    payload TEXT,
+    createData DATETIME NOT NULL,
+    UNIQUE KEY uk_room_seq (roomId, seq),
+    INDEX idx_openid (openid),
+    INDEX idx_peer (peer)
+);
+
+CREATE OR REPLACE VIEW all_scores AS
+    SELECT id, openid, roomId, score, createData FROM scores
+    UNION ALL
+    SELECT id, openid, roomId, score, createData FROM archived_scores;
+
+CREATE OR REPLACE VIEW all_records AS
+    SELECT id, roomId, score, fromUser, toUser, createData FROM records
+    UNION ALL
+    SELECT id, roomId, score, fromUser, toUser, createData FROM archived_records;
+
+CREATE OR REPLACE VIEW all_room_events AS
+    SELECT id, roomId, seq, type, openid, peer, score, recordId, payload, createData FROM room_events
+    UNION ALL
+    SELECT id, roomId, seq, type, openid, peer, score, recordId, payload, createData FROM archived_room_events;
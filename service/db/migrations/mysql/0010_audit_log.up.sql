@@ -0,0 +1,18 @@
+-- 审计日志，只追加不修改
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    actor VARCHAR(255) NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    roomId INT,
+    targetUser VARCHAR(255),
+    beforeValue TEXT,
+    afterValue TEXT,
+    clientIp VARCHAR(64) NOT NULL,
+    userAgent VARCHAR(512) NOT NULL,
+    requestId VARCHAR(64) NOT NULL,
+    createData DATETIME NOT NULL,
+    INDEX idx_roomId (roomId, id),
+    INDEX idx_actor (actor, id),
+    INDEX idx_createData (createData)
+);
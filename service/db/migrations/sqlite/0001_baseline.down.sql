@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS records;
+DROP TABLE IF EXISTS scores;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS users;
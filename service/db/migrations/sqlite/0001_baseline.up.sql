@@ -0,0 +1,38 @@
+-- 初始表结构：用户、房间、分数、记录
+
+CREATE TABLE IF NOT EXISTS users (
+    openid TEXT PRIMARY KEY,
+    nickname TEXT NOT NULL,
+    roomId INTEGER,
+    createData TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rooms (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner TEXT NOT NULL,
+    createData TEXT NOT NULL,
+    opened INTEGER NOT NULL,
+    FOREIGN KEY (owner) REFERENCES users(openid)
+);
+
+CREATE TABLE IF NOT EXISTS scores (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    openid TEXT NOT NULL,
+    roomId INTEGER NOT NULL,
+    score INTEGER NOT NULL,
+    createData TEXT NOT NULL,
+    FOREIGN KEY (openid) REFERENCES users(openid),
+    FOREIGN KEY (roomId) REFERENCES rooms(id)
+);
+
+CREATE TABLE IF NOT EXISTS records (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    roomId INTEGER NOT NULL,
+    score INTEGER NOT NULL,
+    fromUser TEXT NOT NULL,
+    toUser TEXT NOT NULL,
+    createData TEXT NOT NULL,
+    FOREIGN KEY (roomId) REFERENCES rooms(id),
+    FOREIGN KEY (fromUser) REFERENCES users(openid),
+    FOREIGN KEY (toUser) REFERENCES users(openid)
+);
@@ -0,0 +1,22 @@
+-- 好友圈排行榜汇总
+
+CREATE TABLE IF NOT EXISTS player_rollups (
+    openid TEXT NOT NULL,
+    period TEXT NOT NULL,
+    periodKey TEXT NOT NULL,
+    net INTEGER NOT NULL,
+    sessions INTEGER NOT NULL,
+    wins INTEGER NOT NULL,
+    PRIMARY KEY (openid, period, periodKey),
+    FOREIGN KEY (openid) REFERENCES users(openid)
+);
+
+CREATE INDEX IF NOT EXISTS player_rollups_period ON player_rollups (period, periodKey);
+
+CREATE TABLE IF NOT EXISTS player_peers (
+    openid TEXT NOT NULL,
+    peer TEXT NOT NULL,
+    PRIMARY KEY (openid, peer),
+    FOREIGN KEY (openid) REFERENCES users(openid),
+    FOREIGN KEY (peer) REFERENCES users(openid)
+);
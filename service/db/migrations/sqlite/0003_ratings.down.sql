@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS rating_history;
+DROP TABLE IF EXISTS ratings;
+DROP TABLE IF EXISTS room_settings;
@@ -0,0 +1,31 @@
+-- 房间玩法与等级分
+
+CREATE TABLE IF NOT EXISTS room_settings (
+    roomId INTEGER PRIMARY KEY,
+    gameType TEXT NOT NULL,
+    FOREIGN KEY (roomId) REFERENCES rooms(id)
+);
+
+CREATE TABLE IF NOT EXISTS ratings (
+    openid TEXT NOT NULL,
+    gameType TEXT NOT NULL,
+    rating REAL NOT NULL,
+    games INTEGER NOT NULL,
+    PRIMARY KEY (openid, gameType),
+    FOREIGN KEY (openid) REFERENCES users(openid)
+);
+
+CREATE TABLE IF NOT EXISTS rating_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    openid TEXT NOT NULL,
+    gameType TEXT NOT NULL,
+    roomId INTEGER NOT NULL,
+    ratingBefore REAL NOT NULL,
+    ratingAfter REAL NOT NULL,
+    delta REAL NOT NULL,
+    createData TEXT NOT NULL,
+    FOREIGN KEY (openid) REFERENCES users(openid),
+    FOREIGN KEY (roomId) REFERENCES rooms(id)
+);
+
+CREATE INDEX IF NOT EXISTS rating_history_openid_gameType ON rating_history (openid, gameType);
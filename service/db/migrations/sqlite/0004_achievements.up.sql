@@ -0,0 +1,9 @@
+-- 成就
+
+CREATE TABLE IF NOT EXISTS achievements (
+    openid TEXT NOT NULL,
+    achievementId TEXT NOT NULL,
+    unlockData TEXT NOT NULL,
+    PRIMARY KEY (openid, achievementId),
+    FOREIGN KEY (openid) REFERENCES users(openid)
+);
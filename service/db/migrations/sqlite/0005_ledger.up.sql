@@ -0,0 +1,33 @@
+-- 跨房间账本与手动还款
+
+CREATE TABLE IF NOT EXISTS ledger_payments (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    payer TEXT NOT NULL,
+    payee TEXT NOT NULL,
+    points INTEGER NOT NULL,
+    payerConfirmed INTEGER NOT NULL,
+    payeeConfirmed INTEGER NOT NULL,
+    createData TEXT NOT NULL,
+    confirmData TEXT,
+    FOREIGN KEY (payer) REFERENCES users(openid),
+    FOREIGN KEY (payee) REFERENCES users(openid)
+);
+
+CREATE TABLE IF NOT EXISTS ledger (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    debtor TEXT NOT NULL,
+    creditor TEXT NOT NULL,
+    points INTEGER NOT NULL,
+    source TEXT NOT NULL,
+    roomId INTEGER,
+    paymentId INTEGER,
+    createData TEXT NOT NULL,
+    FOREIGN KEY (debtor) REFERENCES users(openid),
+    FOREIGN KEY (creditor) REFERENCES users(openid),
+    FOREIGN KEY (roomId) REFERENCES rooms(id),
+    FOREIGN KEY (paymentId) REFERENCES ledger_payments(id)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_debtor ON ledger (debtor);
+
+CREATE INDEX IF NOT EXISTS ledger_creditor ON ledger (creditor);
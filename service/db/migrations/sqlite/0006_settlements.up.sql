@@ -0,0 +1,18 @@
+-- 房间结算转账与付款状态
+
+CREATE TABLE IF NOT EXISTS settlements (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    roomId INTEGER NOT NULL,
+    fromUser TEXT NOT NULL,
+    toUser TEXT NOT NULL,
+    points INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    remindCount INTEGER NOT NULL,
+    remindData TEXT,
+    claimData TEXT,
+    confirmData TEXT,
+    createData TEXT NOT NULL,
+    FOREIGN KEY (roomId) REFERENCES rooms(id),
+    FOREIGN KEY (fromUser) REFERENCES users(openid),
+    FOREIGN KEY (toUser) REFERENCES users(openid)
+);
@@ -0,0 +1,28 @@
+-- 房间事件日志与投影快照
+
+CREATE TABLE IF NOT EXISTS room_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    roomId INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    openid TEXT,
+    peer TEXT,
+    score INTEGER NOT NULL,
+    recordId INTEGER,
+    payload TEXT,
+    createData TEXT NOT NULL,
+    UNIQUE (roomId, seq),
+    FOREIGN KEY (roomId) REFERENCES rooms(id),
+    FOREIGN KEY (openid) REFERENCES users(openid),
+    FOREIGN KEY (peer) REFERENCES users(openid),
+    FOREIGN KEY (recordId) REFERENCES records(id)
+);
+
+CREATE TABLE IF NOT EXISTS room_snapshots (
+    roomId INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    state TEXT NOT NULL,
+    createData TEXT NOT NULL,
+    PRIMARY KEY (roomId, seq),
+    FOREIGN KEY (roomId) REFERENCES rooms(id)
+);
@@ -0,0 +1,14 @@
+-- 幂等键与首次响应
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    openid TEXT NOT NULL,
+    idempotencyKey TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    contentType TEXT NOT NULL,
+    body BLOB,
+    createData TEXT NOT NULL,
+    PRIMARY KEY (openid, idempotencyKey)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_createData ON idempotency_keys (createData);
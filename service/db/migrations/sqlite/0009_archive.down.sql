@@ -0,0 +1,31 @@
+-- 归档数据移回在线表后删除归档表
+
+DROP VIEW IF EXISTS all_room_events;
+
+DROP VIEW IF EXISTS all_records;
+
+DROP VIEW IF EXISTS all_scores;
+
+INSERT INTO scores SELECT * FROM archived_scores;
+
+INSERT INTO records SELECT * FROM archived_records;
+
+INSERT INTO room_events SELECT * FROM archived_room_events;
+
+DROP TABLE IF EXISTS archived_room_events;
+
+DROP TABLE IF EXISTS archived_records;
+
+DROP TABLE IF EXISTS archived_scores;
+
+DROP TABLE IF EXISTS archived_rooms;
+
+DROP INDEX IF EXISTS records_toUser;
+
+DROP INDEX IF EXISTS records_fromUser;
+
+DROP INDEX IF EXISTS records_room_createData;
+
+DROP INDEX IF EXISTS scores_openid;
+
+DROP INDEX IF EXISTS scores_room_openid;
@@ -0,0 +1,79 @@
+-- 归档已关闭的旧房间：分数、记录、事件移入归档表，房间本身保留，
+-- all_* 视图合并在线表与归档表，供历史战绩和统计查询。
+-- SQLite 不会为外键自动建索引，同时为在线表补上索引
+
+CREATE INDEX IF NOT EXISTS scores_room_openid ON scores (roomId, openid);
+
+CREATE INDEX IF NOT EXISTS scores_openid ON scores (openid);
+
+CREATE INDEX IF NOT EXISTS records_room_createData ON records (roomId, createData);
+
+CREATE INDEX IF NOT EXISTS records_fromUser ON records (fromUser);
+
+CREATE INDEX IF NOT EXISTS records_toUser ON records (toUser);
+
+CREATE TABLE IF NOT EXISTS archived_rooms (
+    roomId INTEGER PRIMARY KEY,
+    archiveData TEXT NOT NULL,
+    FOREIGN KEY (roomId) REFERENCES rooms(id)
+);
+
+CREATE TABLE IF NOT EXISTS archived_scores (
+    id INTEGER PRIMARY KEY,
+    openid TEXT NOT NULL,
+    roomId INTEGER NOT NULL,
+    score INTEGER NOT NULL,
+    createData TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS archived_scores_room_openid ON archived_scores (roomId, openid);
+
+CREATE INDEX IF NOT EXISTS archived_scores_openid ON archived_scores (openid);
+
+CREATE TABLE IF NOT EXISTS archived_records (
+    id INTEGER PRIMARY KEY,
+    roomId INTEGER NOT NULL,
+    score INTEGER NOT NULL,
+    fromUser TEXT NOT NULL,
+    toUser TEXT NOT NULL,
+    createData TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS archived_records_room_createData ON archived_records (roomId, createData);
+
+CREATE INDEX IF NOT EXISTS archived_records_fromUser ON archived_records (fromUser);
+
+CREATE INDEX IF NOT EXISTS archived_records_toUser ON archived_records (toUser);
+
+CREATE TABLE IF NOT EXISTS archived_room_events (
+    id INTEGER PRIMARY KEY,
+    roomId INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    openid TEXT,
+    peer TEXT,
+    score INTEGER NOT NULL,
+    recordId INTEGER,
+    payload TEXT,
+    createData TEXT NOT NULL,
+    UNIQUE (roomId, seq)
+);
+
+CREATE INDEX IF NOT EXISTS archived_room_events_openid ON archived_room_events (openid);
+
+CREATE INDEX IF NOT EXISTS archived_room_events_peer ON archived_room_events (peer);
+
+CREATE VIEW IF NOT EXISTS all_scores AS
+    SELECT id, openid, roomId, score, createData FROM scores
+    UNION ALL
+    SELECT id, openid, roomId, score, createData FROM archived_scores;
+
+CREATE VIEW IF NOT EXISTS all_records AS
+    SELECT id, roomId, score, fromUser, toUser, createData FROM records
+    UNION ALL
+    SELECT id, roomId, score, fromUser, toUser, createData FROM archived_records;
+
+CREATE VIEW IF NOT EXISTS all_room_events AS
+    SELECT id, roomId, seq, type, openid, peer, score, recordId, payload, createData FROM room_events
+    UNION ALL
+    SELECT id, roomId, seq, type, openid, peer, score, recordId, payload, createData FROM archived_room_events;
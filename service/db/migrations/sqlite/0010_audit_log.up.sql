@@ -0,0 +1,19 @@
+-- 审计日志，只追加不修改
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    roomId INTEGER,
+    targetUser TEXT,
+    beforeValue TEXT,
+    afterValue TEXT,
+    clientIp TEXT NOT NULL,
+    userAgent TEXT NOT NULL,
+    requestId TEXT NOT NULL,
+    createData TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_roomId ON audit_log (roomId, id);
+CREATE INDEX IF NOT EXISTS audit_log_actor ON audit_log (actor, id);
+CREATE INDEX IF NOT EXISTS audit_log_createData ON audit_log (createData);
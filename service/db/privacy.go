@@ -0,0 +1,307 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// 注销用户后的占位昵称
+const DeletedNickname = "已注销用户"
+
+// 查询结果转换为列名到值的映射
+func queryMaps(query string, args ...any) ([]map[string]any, error) {
+	result := []map[string]any{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		slog.Error("Error querying rows", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		slog.Error("Error getting columns", "err", err)
+		return nil, err
+	}
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			slog.Error("Error scanning rows", "err", err)
+			return nil, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+// 个人数据导出包含的表及查询
+var personalData = []struct {
+	Name  string
+	Query string
+	// 查询中 openid 参数的个数
+	Args int
+}{
+	{"users", "SELECT * FROM users WHERE openid =?", 1},
+	{"rooms", "SELECT * FROM rooms WHERE owner =? ORDER BY id", 1},
+	{"scores", "SELECT * FROM all_scores WHERE openid =? ORDER BY id", 1},
+	{"records", "SELECT * FROM all_records WHERE fromUser =? OR toUser =? ORDER BY id", 2},
+	{"player_rollups", "SELECT * FROM player_rollups WHERE openid =?", 1},
+	{"player_peers", "SELECT * FROM player_peers WHERE openid =?", 1},
+	{"ratings", "SELECT * FROM ratings WHERE openid =?", 1},
+	{"rating_history", "SELECT * FROM rating_history WHERE openid =? ORDER BY id", 1},
+	{"achievements", "SELECT * FROM achievements WHERE openid =?", 1},
+	{"ledger", "SELECT * FROM ledger WHERE debtor =? OR creditor =? ORDER BY id", 2},
+	{"ledger_payments", "SELECT * FROM ledger_payments WHERE payer =? OR payee =? ORDER BY id", 2},
+	{"settlements", "SELECT * FROM settlements WHERE fromUser =? OR toUser =? ORDER BY id", 2},
+	{"audit_log", "SELECT * FROM audit_log WHERE actor =? OR targetUser =? ORDER BY id", 2},
+}
+
+// 导出与用户 openid 相关的所有数据，返回表名到行的映射
+func ExportUserData(openid string) (map[string][]map[string]any, error) {
+	defer timeQuery("ExportUserData")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	_, err = QueryUser(openid)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string][]map[string]any)
+	for _, table := range personalData {
+		args := make([]any, table.Args)
+		for i := range args {
+			args[i] = openid
+		}
+		data[table.Name], err = queryMaps(table.Query, args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// 注销用户时重新指向占位用户的外键列，保留其他玩家的历史
+var anonymizedColumns = []struct {
+	Table  string
+	Column string
+}{
+	{"rooms", "owner"},
+	{"scores", "openid"},
+	{"records", "fromUser"},
+	{"records", "toUser"},
+	{"ledger", "debtor"},
+	{"ledger", "creditor"},
+	{"ledger_payments", "payer"},
+	{"ledger_payments", "payee"},
+	{"settlements", "fromUser"},
+	{"settlements", "toUser"},
+	{"room_events", "openid"},
+	{"room_events", "peer"},
+	{"archived_scores", "openid"},
+	{"archived_records", "fromUser"},
+	{"archived_records", "toUser"},
+	{"archived_room_events", "openid"},
+	{"archived_room_events", "peer"},
+	{"audit_log", "actor"},
+	{"audit_log", "targetUser"},
+}
+
+// 注销用户后直接删除的个人数据
+var deletedColumns = []struct {
+	Table  string
+	Column string
+}{
+	{"player_rollups", "openid"},
+	{"player_peers", "openid"},
+	{"player_peers", "peer"},
+	{"ratings", "openid"},
+	{"rating_history", "openid"},
+	{"achievements", "openid"},
+}
+
+// 注销用户：其他玩家仍可见的数据改为指向一个新的匿名占位用户，
+// 个人统计数据直接删除，最后删除原用户，返回占位用户 id
+func DeleteUser(openid string) (string, error) {
+	defer timeQuery("DeleteUser")()
+	err := sqlOnly()
+	if err != nil {
+		return "", err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	var roomId sql.NullInt64
+	err = tx.QueryRow("SELECT roomId FROM users WHERE openid =?"+dialect.forUpdate(), openid).Scan(&roomId)
+	if err != nil {
+		slog.Error("Error querying user", "err", err)
+		return "", err
+	}
+	if roomId.Valid {
+		err = errors.New("please exit room first")
+		return "", err
+	}
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	placeholder := "deleted_" + hex.EncodeToString(b)
+	_, err = tx.Exec("INSERT INTO users (openid, nickname, createData) VALUES (?, ?, NOW())", placeholder, DeletedNickname)
+	if err != nil {
+		slog.Error("Error inserting placeholder user", "err", err)
+		return "", err
+	}
+	// 快照中保存了 openid，删除后由事件日志重新生成
+	_, err = tx.Exec("DELETE FROM room_snapshots WHERE roomId IN (SELECT roomId FROM room_events WHERE openid =? OR peer =?)", openid, openid)
+	if err != nil {
+		slog.Error("Error deleting room snapshots", "err", err)
+		return "", err
+	}
+	err = scrubAudit(tx, openid, placeholder)
+	if err != nil {
+		return "", err
+	}
+	// 缓存的响应中可能包含用户数据
+	_, err = tx.Exec("DELETE FROM idempotency_keys WHERE openid =?", openid)
+	if err != nil {
+		slog.Error("Error deleting idempotency keys", "err", err)
+		return "", err
+	}
+	for _, c := range anonymizedColumns {
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s =? WHERE %s =?", c.Table, c.Column, c.Column), placeholder, openid)
+		if err != nil {
+			slog.Error("Error anonymizing table", "table", c.Table, "err", err)
+			return "", err
+		}
+	}
+	for _, c := range deletedColumns {
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s =?", c.Table, c.Column), openid)
+		if err != nil {
+			slog.Error("Error deleting table", "table", c.Table, "err", err)
+			return "", err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM users WHERE openid =?", openid)
+	if err != nil {
+		slog.Error("Error deleting user", "err", err)
+		return "", err
+	}
+	return placeholder, nil
+}
+
+// 改写审计日志修改前后的值：openid 替换为占位用户，
+// 以注销用户为操作对象的日志中的昵称替换为占位昵称
+func scrubAudit(tx *sql.Tx, openid string, placeholder string) error {
+	pattern := "%" + strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(openid) + "%"
+	rows, err := tx.Query(`
+		SELECT id, targetUser, beforeValue, afterValue FROM audit_log
+		WHERE targetUser =? OR beforeValue LIKE ? ESCAPE '!' OR afterValue LIKE ? ESCAPE '!'
+	`, openid, pattern, pattern)
+	if err != nil {
+		slog.Error("Error querying audit log", "err", err)
+		return err
+	}
+	type auditValues struct {
+		id     int
+		values [2]sql.NullString
+	}
+	var scrubbed []auditValues
+	for rows.Next() {
+		var a auditValues
+		var targetUser sql.NullString
+		err = rows.Scan(&a.id, &targetUser, &a.values[0], &a.values[1])
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning audit log", "err", err)
+			return err
+		}
+		for i, v := range a.values {
+			if !v.Valid {
+				continue
+			}
+			a.values[i].String, err = scrubAuditValue(v.String, openid, placeholder, targetUser.String == openid)
+			if err != nil {
+				rows.Close()
+				slog.Error("Error scrubbing audit log", "id", a.id, "err", err)
+				return err
+			}
+		}
+		scrubbed = append(scrubbed, a)
+	}
+	rows.Close()
+	for _, a := range scrubbed {
+		_, err = tx.Exec("UPDATE audit_log SET beforeValue =?, afterValue =? WHERE id =?", a.values[0], a.values[1], a.id)
+		if err != nil {
+			slog.Error("Error updating audit log", "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// 改写一个审计值中的 openid（包括对象的键），nickname 为 true 时同时改写昵称
+func scrubAuditValue(value string, openid string, placeholder string, nickname bool) (string, error) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var v any
+	err := decoder.Decode(&v)
+	if err != nil {
+		return "", err
+	}
+	var scrub func(v any) any
+	scrub = func(v any) any {
+		switch v := v.(type) {
+		case string:
+			if v == openid {
+				return placeholder
+			}
+		case []any:
+			for i := range v {
+				v[i] = scrub(v[i])
+			}
+		case map[string]any:
+			result := make(map[string]any, len(v))
+			for key, item := range v {
+				if nickname && key == "nickname" {
+					item = DeletedNickname
+				}
+				if key == openid {
+					key = placeholder
+				}
+				result[key] = scrub(item)
+			}
+			return result
+		}
+		return v
+	}
+	data, err := json.Marshal(scrub(v))
+	return string(data), err
+}
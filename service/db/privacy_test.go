@@ -0,0 +1,50 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 注销后审计日志和幂等键中不再包含用户的 openid 和昵称
+func TestDeleteUserScrubsAudit(t *testing.T) {
+	useSqlite(t)
+	must(t, RegisterUser("openid_alice", "Alice"))
+	must(t, RegisterUser("openid_bob", "Bob"))
+	entries := []AuditEntry{
+		{Actor: "openid_alice", Action: "user.register", TargetUser: "openid_alice", After: json.RawMessage(`{"nickname":"Alice"}`)},
+		{Actor: "openid_alice", Action: "user.nickname", TargetUser: "openid_alice", Before: json.RawMessage(`{"nickname":"Alice"}`), After: json.RawMessage(`{"nickname":"Alice2"}`)},
+		{Actor: "openid_bob", Action: "record.add", RoomId: 1, TargetUser: "openid_bob", Before: json.RawMessage(`{"scores":{"openid_alice":0,"openid_bob":0}}`), After: json.RawMessage(`{"fromUser":"openid_alice","toUser":"openid_bob","score":5,"scores":{"openid_alice":-5,"openid_bob":5}}`)},
+		{Actor: "openid_bob", Action: "payment.create", TargetUser: "openid_alice", After: json.RawMessage(`{"id":1,"payer":"openid_bob","payee":"openid_alice","points":5}`)},
+		{Actor: "openid_bob", Action: "user.nickname", TargetUser: "openid_bob", Before: json.RawMessage(`{"nickname":"Bob"}`), After: json.RawMessage(`{"nickname":"Bobby"}`)},
+	}
+	for _, e := range entries {
+		must(t, AppendAudit(e))
+	}
+	_, err := BeginIdempotent("openid_alice", "key", "fingerprint", time.Hour)
+	must(t, err)
+
+	placeholder, err := DeleteUser("openid_alice")
+	must(t, err)
+	logged, err := QueryAudit(AuditFilter{})
+	must(t, err)
+	data, err := json.Marshal(logged)
+	must(t, err)
+	for _, personal := range []string{"openid_alice", "Alice"} {
+		if strings.Contains(string(data), personal) {
+			t.Errorf("audit log still contains %q: %s", personal, data)
+		}
+	}
+	// 其他玩家的数据保留，注销用户的 openid 改为占位用户
+	for _, kept := range []string{`"openid_bob":5`, `"payee":"` + placeholder + `"`, `"nickname":"Bobby"`} {
+		if !strings.Contains(string(data), kept) {
+			t.Errorf("audit log lost %s: %s", kept, data)
+		}
+	}
+	var keys int
+	must(t, db.QueryRow("SELECT COUNT(*) FROM idempotency_keys WHERE openid = 'openid_alice'").Scan(&keys))
+	if keys != 0 {
+		t.Errorf("%d idempotency keys left", keys)
+	}
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"scoringMP/service/rating"
+)
+
+// 未设置玩法的房间使用的默认玩法
+const DefaultGameType = "default"
+
+// 查询房间玩法
+func queryGameType(tx *sql.Tx, roomId int) (string, error) {
+	var gameType string
+	err := tx.QueryRow("SELECT gameType FROM room_settings WHERE roomId =?", roomId).Scan(&gameType)
+	if err == sql.ErrNoRows {
+		return DefaultGameType, nil
+	}
+	if err != nil {
+		slog.Error("Error querying room game type", "err", err)
+		return "", err
+	}
+	return gameType, nil
+}
+
+// 房间关闭时根据名次更新等级分
+func rateRoom(tx *sql.Tx, roomId int) error {
+	gameType, err := queryGameType(tx, roomId)
+	if err != nil {
+		return err
+	}
+	var createData string
+	err = tx.QueryRow("SELECT createData FROM rooms WHERE id =?", roomId).Scan(&createData)
+	if err != nil {
+		slog.Error("Error querying room", "err", err)
+		return err
+	}
+	rows, err := tx.Query("SELECT openid, score FROM all_scores WHERE roomId =?", roomId)
+	if err != nil {
+		slog.Error("Error querying room scores", "err", err)
+		return err
+	}
+	var openids []string
+	var players []rating.Player
+	for rows.Next() {
+		var openid string
+		var player rating.Player
+		err = rows.Scan(&openid, &player.Score)
+		if err != nil {
+			rows.Close()
+			slog.Error("Error scanning room scores", "err", err)
+			return err
+		}
+		openids = append(openids, openid)
+		players = append(players, player)
+	}
+	rows.Close()
+	// 单人房间不计算等级分
+	if len(players) < 2 {
+		return nil
+	}
+	for i, openid := range openids {
+		err = tx.QueryRow("SELECT rating FROM ratings WHERE openid =? AND gameType =?"+dialect.forUpdate(), openid, gameType).Scan(&players[i].Rating)
+		if err == sql.ErrNoRows {
+			players[i].Rating = rating.Initial
+			err = nil
+		}
+		if err != nil {
+			slog.Error("Error querying rating", "err", err)
+			return err
+		}
+	}
+	deltas := rating.Update(players)
+	for i, openid := range openids {
+		after := players[i].Rating + deltas[i]
+		_, err = tx.Exec(fmt.Sprintf(`
+			INSERT INTO ratings (openid, gameType, rating, games) VALUES (?,?,?,1)
+			%s rating = %s, games = games + 1
+		`, dialect.onConflict("openid, gameType"), dialect.excluded("rating")), openid, gameType, after)
+		if err != nil {
+			slog.Error("Error updating rating", "err", err)
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO rating_history (openid, gameType, roomId, ratingBefore, ratingAfter, delta, createData)
+			VALUES (?,?,?,?,?,?,?)
+		`, openid, gameType, roomId, players[i].Rating, after, deltas[i], createData)
+		if err != nil {
+			slog.Error("Error inserting rating history", "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// 根据已关闭房间从头重算所有等级分
+func RecomputeRatings() error {
+	defer timeQuery("RecomputeRatings")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	_, err = tx.Exec("DELETE FROM rating_history")
+	if err != nil {
+		slog.Error("Error clearing rating history", "err", err)
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM ratings")
+	if err != nil {
+		slog.Error("Error clearing ratings", "err", err)
+		return err
+	}
+	roomIds, err := queryClosedRooms(tx)
+	if err != nil {
+		return err
+	}
+	for _, roomId := range roomIds {
+		err = rateRoom(tx, roomId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type PlayerRating struct {
+	GameType string  `json:"gameType"`
+	Rating   float64 `json:"rating"`
+	Games    int     `json:"games"`
+}
+
+// 获取玩家各玩法的等级分
+func GetRatings(openid string) ([]PlayerRating, error) {
+	defer timeQuery("GetRatings")()
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	return readReplica(db, func(q queryer) ([]PlayerRating, error) {
+		ratings := []PlayerRating{}
+		rows, err := q.Query("SELECT gameType, rating, games FROM ratings WHERE openid =? ORDER BY games DESC", openid)
+		if err != nil {
+			slog.Error("Error querying ratings", "err", err)
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var r PlayerRating
+			err = rows.Scan(&r.GameType, &r.Rating, &r.Games)
+			if err != nil {
+				slog.Error("Error scanning ratings", "err", err)
+				return nil, err
+			}
+			ratings = append(ratings, r)
+		}
+		return ratings, nil
+	})
+}
+
+type RatingChange struct {
+	Openid   string  `json:"openid"`
+	Nickname string  `json:"nickname"`
+	GameType string  `json:"gameType"`
+	RoomId   int     `json:"roomId"`
+	Before   float64 `json:"before"`
+	After    float64 `json:"after"`
+	Delta    float64 `json:"delta"`
+	Time     string  `json:"time"`
+}
+
+func queryRatingChanges(query string, args ...any) ([]RatingChange, error) {
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	return readReplica(db, func(q queryer) ([]RatingChange, error) {
+		changes := []RatingChange{}
+		rows, err := q.Query(query, args...)
+		if err != nil {
+			slog.Error("Error querying rating history", "err", err)
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var c RatingChange
+			err = rows.Scan(&c.Openid, &c.Nickname, &c.GameType, &c.RoomId, &c.Before, &c.After, &c.Delta, &c.Time)
+			if err != nil {
+				slog.Error("Error scanning rating history", "err", err)
+				return nil, err
+			}
+			changes = append(changes, c)
+		}
+		return changes, nil
+	})
+}
+
+// 获取玩家某玩法的等级分历史
+func GetRatingHistory(openid string, gameType string) ([]RatingChange, error) {
+	defer timeQuery("GetRatingHistory")()
+	return queryRatingChanges(`
+		SELECT h.openid, u.nickname, h.gameType, h.roomId, h.ratingBefore, h.ratingAfter, h.delta, h.createData
+		FROM rating_history h
+		JOIN users u ON h.openid = u.openid
+		WHERE h.openid =? AND h.gameType =?
+		ORDER BY h.createData, h.id
+	`, openid, gameType)
+}
+
+// 获取某房间每位玩家的等级分变化
+func GetRoomRatingChanges(roomId int) ([]RatingChange, error) {
+	defer timeQuery("GetRoomRatingChanges")()
+	return queryRatingChanges(`
+		SELECT h.openid, u.nickname, h.gameType, h.roomId, h.ratingBefore, h.ratingAfter, h.delta, h.createData
+		FROM rating_history h
+		JOIN users u ON h.openid = u.openid
+		WHERE h.roomId =?
+		ORDER BY h.delta DESC
+	`, roomId)
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"scoringMP/config"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// 只读从库，历史、统计和房间详情查询轮流发往从库
+var replicas []*sql.DB
+
+var replicaNext atomic.Uint32
+
+// 打开配置的从库。从库暂时不可用不影响启动，查询时会回退到主库
+func openReplicas() ([]*sql.DB, error) {
+	var opened []*sql.DB
+	for _, dsn := range config.Config.Database.Replicas {
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			slog.Error("Error parsing replica dsn", "err", err)
+			return nil, err
+		}
+		if cfg.DBName == "" {
+			return nil, fmt.Errorf("replica %s has no database name", cfg.Addr)
+		}
+		replica, err := sql.Open("mysql", cfg.FormatDSN())
+		if err != nil {
+			slog.Error("Error opening replica", "err", err)
+			return nil, err
+		}
+		configurePool(replica)
+		err = replica.Ping()
+		if err != nil {
+			slog.Warn("Error pinging replica", "addr", cfg.Addr, "err", err)
+		}
+		opened = append(opened, replica)
+	}
+	return opened, nil
+}
+
+// 在从库上执行只读查询，从库出错时在主库上重试。
+// 没有配置从库时直接查询主库；查询结果为空（sql.ErrNoRows）不重试
+func readReplica[T any](primary *sql.DB, read func(q queryer) (T, error)) (T, error) {
+	if len(replicas) > 0 {
+		replica := replicas[replicaNext.Add(1)%uint32(len(replicas))]
+		result, err := read(replica)
+		if err == nil || errors.Is(err, sql.ErrNoRows) {
+			return result, err
+		}
+		slog.Warn("Error reading from replica, falling back to primary", "err", err)
+	}
+	return read(primary)
+}
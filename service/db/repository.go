@@ -0,0 +1,161 @@
+package db
+
+import (
+	"errors"
+	"scoringMP/model"
+)
+
+// 存储后端
+const (
+	StorageMysql  = "mysql"
+	StorageSqlite = "sqlite"
+	// 内存存储，进程退出后数据丢失，用于测试
+	StorageMemory = "memory"
+)
+
+// 功能表依赖 SQL，内存存储不支持
+var ErrUnsupported = errors.New("not supported by memory storage")
+
+// 用户、房间、分数、记录的存储接口
+type Repository interface {
+	// 用户
+	QueryUser(openid string) (model.User, error)
+	RegisterUser(openid string, nickname string) error
+	UpdateNickname(openid string, nickname string) error
+	QueryUserRoom(openid string) (model.Room, error)
+	QueryHistory(openid string) ([]HistoryItem, error)
+
+	// 房间
+	CreateRoom(openid string, gameType string) (int, error)
+	JoinRoom(openid string, roomId int) error
+	QuitRoom(openid string, roomId int) (bool, error)
+	QueryRoom(roomId int) (model.Room, error)
+	CheckRoom(roomId int) (bool, error)
+	GetRoomGameType(roomId int) (string, error)
+
+	// 分数
+	GetRoomUsers(roomId int) ([]UserScore, error)
+	GetRoomScores(roomId int) ([]model.Score, error)
+
+	// 记录
+	AddRecord(roomId int, fromUser string, toUser string, score int) error
+	GetRoomRecords(roomId int) ([]UserRecord, error)
+	GetRoomRecordRows(roomId int) ([]model.Record, error)
+	GetBiggestRecord(roomId int) (UserRecord, error)
+}
+
+var repo Repository
+
+// 功能表只能在 SQL 存储上使用
+func sqlOnly() error {
+	if db == nil {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// 查询用户
+func QueryUser(openid string) (model.User, error) {
+	defer timeQuery("QueryUser")()
+	return repo.QueryUser(openid)
+}
+
+// 注册用户
+func RegisterUser(openid string, nickname string) error {
+	defer timeQuery("RegisterUser")()
+	return repo.RegisterUser(openid, nickname)
+}
+
+// 修改昵称
+func UpdateNickname(openid string, nickname string) error {
+	defer timeQuery("UpdateNickname")()
+	return repo.UpdateNickname(openid, nickname)
+}
+
+// 查询用户房间
+func QueryUserRoom(openid string) (model.Room, error) {
+	defer timeQuery("QueryUserRoom")()
+	return repo.QueryUserRoom(openid)
+}
+
+// 查询历史战绩
+func QueryHistory(openid string) ([]HistoryItem, error) {
+	defer timeQuery("QueryHistory")()
+	return repo.QueryHistory(openid)
+}
+
+// 创建/回到房间
+func CreateRoom(openid string, gameType string) (int, error) {
+	defer timeQuery("CreateRoom")()
+	return repo.CreateRoom(openid, gameType)
+}
+
+// 加入房间
+func JoinRoom(openid string, roomId int) error {
+	defer timeQuery("JoinRoom")()
+	return repo.JoinRoom(openid, roomId)
+}
+
+// 退出房间
+func QuitRoom(openid string, roomId int) (bool, error) {
+	defer timeQuery("QuitRoom")()
+	return repo.QuitRoom(openid, roomId)
+}
+
+// 查询房间
+func QueryRoom(roomId int) (model.Room, error) {
+	defer timeQuery("QueryRoom")()
+	return repo.QueryRoom(roomId)
+}
+
+// 检查房间是否关闭
+func CheckRoom(roomId int) (bool, error) {
+	defer timeQuery("CheckRoom")()
+	return repo.CheckRoom(roomId)
+}
+
+// 查询房间玩法
+func GetRoomGameType(roomId int) (string, error) {
+	defer timeQuery("GetRoomGameType")()
+	return repo.GetRoomGameType(roomId)
+}
+
+// 获取房间用户列表及其 score
+func GetRoomUsers(roomId int) ([]UserScore, error) {
+	defer timeQuery("GetRoomUsers")()
+	return repo.GetRoomUsers(roomId)
+}
+
+// 获取房间内所有用户积分
+func GetRoomScores(roomId int) ([]model.Score, error) {
+	defer timeQuery("GetRoomScores")()
+	return repo.GetRoomScores(roomId)
+}
+
+// 计分
+func AddRecord(roomId int, fromUser string, toUser string, score int) error {
+	defer timeQuery("AddRecord")()
+	err := repo.AddRecord(roomId, fromUser, toUser, score)
+	if err == nil {
+		recordsCreated.Inc()
+	}
+	return err
+}
+
+// 获取房间分数列表
+func GetRoomRecords(roomId int) ([]UserRecord, error) {
+	defer timeQuery("GetRoomRecords")()
+	return repo.GetRoomRecords(roomId)
+}
+
+// 按时间顺序获取房间内的原始记录
+func GetRoomRecordRows(roomId int) ([]model.Record, error) {
+	defer timeQuery("GetRoomRecordRows")()
+	return repo.GetRoomRecordRows(roomId)
+}
+
+// 获取房间单笔分数最大的记录
+func GetBiggestRecord(roomId int) (UserRecord, error) {
+	defer timeQuery("GetBiggestRecord")()
+	return repo.GetBiggestRecord(roomId)
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"scoringMP/model"
+	"time"
+)
+
+// 结算转账状态
+const (
+	SettlementUnpaid = "unpaid"
+	// 付款方声明已付款
+	SettlementClaimed = "claimed"
+	// 收款方确认已收款
+	SettlementConfirmed = "confirmed"
+)
+
+// 同一笔转账两次提醒的最小间隔
+const remindInterval = 10 * time.Minute
+
+type SettlementItem struct {
+	model.Settlement
+	FromNickname string `json:"fromNickname"`
+	ToNickname   string `json:"toNickname"`
+}
+
+func querySettlements(query string, args ...any) ([]SettlementItem, error) {
+	err := sqlOnly()
+	if err != nil {
+		return nil, err
+	}
+	items := []SettlementItem{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		slog.Error("Error querying settlements", "err", err)
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item SettlementItem
+		s := &item.Settlement
+		err = rows.Scan(&s.Id, &s.RoomId, &s.FromUser, &s.ToUser, &s.Points, &s.Status, &s.RemindCount, &s.RemindData, &s.ClaimData, &s.ConfirmData, &s.CreateData, &item.FromNickname, &item.ToNickname)
+		if err != nil {
+			slog.Error("Error scanning settlements", "err", err)
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// 获取房间的结算转账及付款状态
+func GetRoomSettlements(roomId int) ([]SettlementItem, error) {
+	defer timeQuery("GetRoomSettlements")()
+	return querySettlements(`
+		SELECT t.*, u1.nickname, u2.nickname
+		FROM settlements t
+		JOIN users u1 ON t.fromUser = u1.openid
+		JOIN users u2 ON t.toUser = u2.openid
+		WHERE t.roomId =?
+		ORDER BY t.id
+	`, roomId)
+}
+
+// 获取用户所有未确认的结算转账
+func GetPendingSettlements(openid string) ([]SettlementItem, error) {
+	defer timeQuery("GetPendingSettlements")()
+	return querySettlements(`
+		SELECT t.*, u1.nickname, u2.nickname
+		FROM settlements t
+		JOIN users u1 ON t.fromUser = u1.openid
+		JOIN users u2 ON t.toUser = u2.openid
+		WHERE (t.fromUser =? OR t.toUser =?) AND t.status <>?
+		ORDER BY t.createData DESC, t.id
+	`, openid, openid, SettlementConfirmed)
+}
+
+// 查询结算转账并加锁
+func querySettlementForUpdate(tx *sql.Tx, id int) (model.Settlement, error) {
+	var s model.Settlement
+	err := tx.QueryRow("SELECT * FROM settlements WHERE id =?"+dialect.forUpdate(), id).Scan(&s.Id, &s.RoomId, &s.FromUser, &s.ToUser, &s.Points, &s.Status, &s.RemindCount, &s.RemindData, &s.ClaimData, &s.ConfirmData, &s.CreateData)
+	if err != nil {
+		slog.Error("Error querying settlement", "err", err)
+	}
+	return s, err
+}
+
+// 查询结算转账
+func GetSettlement(id int) (model.Settlement, error) {
+	defer timeQuery("GetSettlement")()
+	var s model.Settlement
+	err := sqlOnly()
+	if err != nil {
+		return s, err
+	}
+	err = db.QueryRow("SELECT * FROM settlements WHERE id =?", id).Scan(&s.Id, &s.RoomId, &s.FromUser, &s.ToUser, &s.Points, &s.Status, &s.RemindCount, &s.RemindData, &s.ClaimData, &s.ConfirmData, &s.CreateData)
+	if err != nil {
+		slog.Error("Error querying settlement", "err", err)
+	}
+	return s, err
+}
+
+// 付款方声明已付款
+func ClaimSettlement(openid string, id int) error {
+	defer timeQuery("ClaimSettlement")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	s, err := querySettlementForUpdate(tx, id)
+	if err != nil {
+		return err
+	}
+	if s.FromUser != openid {
+		err = errors.New("user is not payer")
+		return err
+	}
+	if s.Status != SettlementUnpaid {
+		err = errors.New("settlement is not unpaid")
+		return err
+	}
+	_, err = tx.Exec("UPDATE settlements SET status =?, claimData = NOW() WHERE id =?", SettlementClaimed, id)
+	if err != nil {
+		slog.Error("Error updating settlement", "err", err)
+		return err
+	}
+	return nil
+}
+
+// 收款方确认已收款，并在账本中冲减对应欠款
+func ConfirmSettlement(openid string, id int) error {
+	defer timeQuery("ConfirmSettlement")()
+	err := sqlOnly()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	s, err := querySettlementForUpdate(tx, id)
+	if err != nil {
+		return err
+	}
+	if s.ToUser != openid {
+		err = errors.New("user is not payee")
+		return err
+	}
+	if s.Status == SettlementConfirmed {
+		err = errors.New("settlement is already confirmed")
+		return err
+	}
+	_, err = tx.Exec("UPDATE settlements SET status =?, confirmData = NOW() WHERE id =?", SettlementConfirmed, id)
+	if err != nil {
+		slog.Error("Error updating settlement", "err", err)
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO ledger (debtor, creditor, points, source, roomId, createData) VALUES (?,?,?,?,?, NOW())", s.ToUser, s.FromUser, s.Points, LedgerSettlementPaid, s.RoomId)
+	if err != nil {
+		slog.Error("Error inserting ledger", "err", err)
+		return err
+	}
+	return nil
+}
+
+// 收款方提醒付款方付款，返回更新后的转账
+func RemindSettlement(openid string, id int) (model.Settlement, error) {
+	defer timeQuery("RemindSettlement")()
+	err := sqlOnly()
+	if err != nil {
+		return model.Settlement{}, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		return model.Settlement{}, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			tx.Commit()
+		}
+	}()
+	s, err := querySettlementForUpdate(tx, id)
+	if err != nil {
+		return s, err
+	}
+	if s.ToUser != openid {
+		err = errors.New("user is not payee")
+		return s, err
+	}
+	if s.Status == SettlementConfirmed {
+		err = errors.New("settlement is already confirmed")
+		return s, err
+	}
+	if s.RemindData.Valid {
+		var last time.Time
+		last, err = time.ParseInLocation(timeLayout, s.RemindData.String, time.Local)
+		if err != nil {
+			slog.Error("Error parsing remindData", "err", err)
+			return s, err
+		}
+		if time.Since(last) < remindInterval {
+			err = errors.New("remind too frequently")
+			return s, err
+		}
+	}
+	_, err = tx.Exec("UPDATE settlements SET remindCount = remindCount + 1, remindData = NOW() WHERE id =?", id)
+	if err != nil {
+		slog.Error("Error updating settlement", "err", err)
+		return s, err
+	}
+	s.RemindCount++
+	return s, nil
+}
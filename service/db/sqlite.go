@@ -0,0 +1,37 @@
+package db
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// 注册带 NOW() 函数的 SQLite 驱动，使 MySQL 风格的语句可以直接复用
+func init() {
+	sql.Register("sqlite3_scoring", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("NOW", func() string {
+				return time.Now().Format(timeLayout)
+			}, false)
+		},
+	})
+}
+
+// 打开 SQLite 数据库：开启外键约束，WAL 模式下读写并发，
+// 事务开始即获取写锁，避免两个事务先读后写时互相等待
+func openSqlite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3_scoring", "file:"+path+"?_foreign_keys=1&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		slog.Error("Error opening sqlite", "err", err)
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		slog.Error("Error pinging sqlite", "err", err)
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,46 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+)
+
+func TestWriteCSVEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, Sheet{Rows: [][]string{
+		{"昵称", "得分"},
+		{"=HYPERLINK(\"http://x\")", "-5"},
+		{"@SUM(A1)", "+3"},
+		{"-悠悠", "0"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("\xEF\xBB\xBF")) {
+		t.Error("missing UTF-8 BOM")
+	}
+	rows, err := csv.NewReader(bytes.NewReader(buf.Bytes()[3:])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"昵称", "得分"},
+		{"'=HYPERLINK(\"http://x\")", "-5"},
+		{"'@SUM(A1)", "+3"},
+		{"'-悠悠", "0"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %q, want %q", rows, want)
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	tests := map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"}
+	for i, want := range tests {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
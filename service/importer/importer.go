@@ -0,0 +1,186 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"scoringMP/service/settle"
+)
+
+// 支持的日期格式
+var dateLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04",
+	"2006/01/02",
+}
+
+// 一条转账，付分方 From 付给得分方 To
+type Transfer struct {
+	From  string
+	To    string
+	Score int
+}
+
+// 一局对局
+type Session struct {
+	// 对局标识，未提供 session 列时为日期
+	Key  string
+	Date time.Time
+	// 按出现顺序排列的玩家名
+	Players []string
+	// 每位玩家的最终得分
+	Results   map[string]int
+	Transfers []Transfer
+}
+
+// 解析出错的行
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e LineError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		t, err := time.ParseInLocation(layout, strings.TrimSpace(value), time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %s", value)
+}
+
+// 解析 CSV，首行为表头，支持两种格式：
+//
+//	date,session,player,score      每位玩家的最终得分，每局需总和为零
+//	date,session,from,to,score     逐笔转账
+//
+// session 列可省略，省略时同一日期视为同一局
+func Parse(r io.Reader) ([]Session, []LineError) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, []LineError{{Line: 0, Err: err}}
+	}
+	if len(rows) == 0 {
+		return nil, []LineError{{Line: 0, Err: errors.New("empty file")}}
+	}
+	header := make(map[string]int)
+	for i, name := range rows[0] {
+		header[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\xEF\xBB\xBF")))] = i
+	}
+	_, hasPlayer := header["player"]
+	_, hasFrom := header["from"]
+	_, hasTo := header["to"]
+	_, hasDate := header["date"]
+	_, hasScore := header["score"]
+	if !hasDate || !hasScore || hasPlayer == (hasFrom && hasTo) {
+		return nil, []LineError{{Line: 1, Err: errors.New("header must be date,[session,]player,score or date,[session,]from,to,score")}}
+	}
+	get := func(row []string, column string) string {
+		i, ok := header[column]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	var sessions []*Session
+	byKey := make(map[string]*Session)
+	var errs []LineError
+	for i, row := range rows[1:] {
+		line := i + 2
+		date, err := parseDate(get(row, "date"))
+		if err != nil {
+			errs = append(errs, LineError{line, err})
+			continue
+		}
+		score, err := strconv.Atoi(get(row, "score"))
+		if err != nil {
+			errs = append(errs, LineError{line, fmt.Errorf("invalid score: %s", get(row, "score"))})
+			continue
+		}
+		key := get(row, "session")
+		if key == "" {
+			key = date.Format("2006-01-02")
+		}
+		session, ok := byKey[key]
+		if !ok {
+			session = &Session{Key: key, Date: date, Results: make(map[string]int)}
+			byKey[key] = session
+			sessions = append(sessions, session)
+		}
+		addPlayer := func(name string) {
+			if _, ok := session.Results[name]; !ok {
+				session.Results[name] = 0
+				session.Players = append(session.Players, name)
+			}
+		}
+		if hasPlayer {
+			name := get(row, "player")
+			if name == "" {
+				errs = append(errs, LineError{line, errors.New("player is required")})
+				continue
+			}
+			if _, ok := session.Results[name]; ok {
+				errs = append(errs, LineError{line, fmt.Errorf("duplicate player %s in session %s", name, key)})
+				continue
+			}
+			addPlayer(name)
+			session.Results[name] = score
+			continue
+		}
+		from, to := get(row, "from"), get(row, "to")
+		if from == "" || to == "" || from == to {
+			errs = append(errs, LineError{line, errors.New("from and to must be two different players")})
+			continue
+		}
+		if score <= 0 {
+			errs = append(errs, LineError{line, errors.New("transfer score must be positive")})
+			continue
+		}
+		addPlayer(from)
+		addPlayer(to)
+		session.Results[from] -= score
+		session.Results[to] += score
+		session.Transfers = append(session.Transfers, Transfer{From: from, To: to, Score: score})
+	}
+
+	result := make([]Session, 0, len(sessions))
+	for _, session := range sessions {
+		if hasPlayer {
+			// 由最终得分推出最少转账作为记录
+			balances := make([]settle.Balance, 0, len(session.Players))
+			for _, name := range session.Players {
+				balances = append(balances, settle.Balance{Openid: name, Points: session.Results[name]})
+			}
+			transfers, err := settle.Minimize(balances)
+			if err != nil {
+				errs = append(errs, LineError{0, fmt.Errorf("session %s: %v", session.Key, err)})
+				continue
+			}
+			for _, t := range transfers {
+				session.Transfers = append(session.Transfers, Transfer{From: t.From, To: t.To, Score: t.Points})
+			}
+		}
+		result = append(result, *session)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Date.Before(result[j].Date) })
+	return result, errs
+}
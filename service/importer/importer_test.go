@@ -0,0 +1,79 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScores(t *testing.T) {
+	csv := "\xEF\xBB\xBFdate,session,player,score\n" +
+		"2024-01-02,b,张三,5\n" +
+		"2024-01-02,b,李四,-5\n" +
+		"2024-01-01,a,张三,-3\n" +
+		"2024-01-01,a,王五,3\n"
+	sessions, errs := Parse(strings.NewReader(csv))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(sessions) != 2 || sessions[0].Key != "a" || sessions[1].Key != "b" {
+		t.Fatalf("sessions not sorted by date: %+v", sessions)
+	}
+	want := []Transfer{{From: "李四", To: "张三", Score: 5}}
+	if !reflect.DeepEqual(sessions[1].Transfers, want) {
+		t.Errorf("got transfers %+v, want %+v", sessions[1].Transfers, want)
+	}
+	if !reflect.DeepEqual(sessions[1].Players, []string{"张三", "李四"}) {
+		t.Errorf("got players %v", sessions[1].Players)
+	}
+}
+
+func TestParseTransfers(t *testing.T) {
+	csv := "date,from,to,score\n" +
+		"2024-01-01 20:00,a,b,3\n" +
+		"2024-01-01 21:00,b,c,1\n"
+	sessions, errs := Parse(strings.NewReader(csv))
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	// 未提供 session 列时同一天为同一局
+	if len(sessions) != 1 || sessions[0].Key != "2024-01-01" {
+		t.Fatalf("got sessions %+v", sessions)
+	}
+	want := map[string]int{"a": -3, "b": 2, "c": 1}
+	if !reflect.DeepEqual(sessions[0].Results, want) {
+		t.Errorf("got results %v, want %v", sessions[0].Results, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		// 出错的行号，0 表示整局或整个文件的错误
+		lines []int
+	}{
+		{"empty file", "", []int{0}},
+		{"bad header", "date,name,score\n", []int{1}},
+		{"both formats", "date,player,from,to,score\n", []int{1}},
+		{"not zero-sum", "date,player,score\n2024-01-01,a,5\n2024-01-01,b,-4\n", []int{0}},
+		// 重复行被跳过后该局也不再和为零
+		{"duplicate player", "date,player,score\n2024-01-01,a,5\n2024-01-01,a,-5\n", []int{3, 0}},
+		{"invalid date", "date,player,score\nyesterday,a,0\n", []int{2}},
+		{"invalid score", "date,player,score\n2024-01-01,a,x\n", []int{2}},
+		{"self transfer", "date,from,to,score\n2024-01-01,a,a,5\n", []int{2}},
+		{"non-positive transfer", "date,from,to,score\n2024-01-01,a,b,0\n", []int{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := Parse(strings.NewReader(tt.csv))
+			var lines []int
+			for _, err := range errs {
+				lines = append(lines, err.Line)
+			}
+			if !reflect.DeepEqual(lines, tt.lines) {
+				t.Errorf("got errors %v, want lines %v", errs, tt.lines)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package mp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"scoringMP/config"
+)
+
+type accessTokenData struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	ErrCode     int    `json:"errcode"`
+	ErrMsg      string `json:"errmsg"`
+}
+
+// access_token 缓存，有效期 2 小时，提前 5 分钟刷新
+var tokenCache struct {
+	sync.Mutex
+	token    string
+	expireAt time.Time
+}
+
+// access_token 缓存状态：empty（未获取）、valid、expired 或 refreshing（正在获取），
+// valid 时同时返回过期时间
+func TokenState() (string, time.Time) {
+	if !tokenCache.TryLock() {
+		return "refreshing", time.Time{}
+	}
+	defer tokenCache.Unlock()
+	switch {
+	case tokenCache.token == "":
+		return "empty", time.Time{}
+	case time.Now().Before(tokenCache.expireAt):
+		return "valid", tokenCache.expireAt
+	}
+	return "expired", tokenCache.expireAt
+}
+
+// 获取接口调用凭证
+func GetAccessToken(ctx context.Context) (string, error) {
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+	if tokenCache.token != "" && time.Now().Before(tokenCache.expireAt) {
+		return tokenCache.token, nil
+	}
+	data, err := fetchAccessToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	tokenCache.token = data.AccessToken
+	tokenCache.expireAt = time.Now().Add(time.Duration(data.ExpiresIn)*time.Second - 5*time.Minute)
+	return tokenCache.token, nil
+}
+
+// 向微信请求新的 access_token
+func fetchAccessToken(ctx context.Context) (data accessTokenData, err error) {
+	defer observeCall("token", time.Now(), &err)
+	url := fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s", config.Config.AppId, config.Config.AppSecret)
+	resp, err := get(ctx, url)
+	if err != nil {
+		return data, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return data, err
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return data, errors.New("parse JSON failed")
+	}
+	if data.ErrCode != 0 {
+		return data, &apiError{data.ErrCode, data.ErrMsg}
+	}
+	return data, nil
+}
+
+type messageValue struct {
+	Value string `json:"value"`
+}
+
+type subscribeMessage struct {
+	ToUser     string                  `json:"touser"`
+	TemplateId string                  `json:"template_id"`
+	Page       string                  `json:"page,omitempty"`
+	Data       map[string]messageValue `json:"data"`
+}
+
+// 发送订阅消息，data 为模板字段到内容的映射
+func SendSubscribeMessage(ctx context.Context, openid string, templateId string, page string, data map[string]string) error {
+	token, err := GetAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	return sendSubscribeMessage(ctx, token, openid, templateId, page, data)
+}
+
+func sendSubscribeMessage(ctx context.Context, token string, openid string, templateId string, page string, data map[string]string) (err error) {
+	defer observeCall("subscribe_send", time.Now(), &err)
+	msg := subscribeMessage{ToUser: openid, TemplateId: templateId, Page: page, Data: map[string]messageValue{}}
+	for k, v := range data {
+		msg.Data[k] = messageValue{Value: v}
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.weixin.qq.com/cgi-bin/message/subscribe/send?access_token="+token, bytes.NewReader(payload))
+	if err != nil {
+		return redactURLError(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return redactURLError(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var result accessTokenData
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return errors.New("parse JSON failed")
+	}
+	if result.ErrCode != 0 {
+		return &apiError{result.ErrCode, result.ErrMsg}
+	}
+	return nil
+}
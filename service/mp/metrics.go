@@ -0,0 +1,40 @@
+package mp
+
+import (
+	"errors"
+	"log/slog"
+	"scoringMP/metrics"
+	"time"
+)
+
+var (
+	apiCalls    = metrics.NewCounterVec("scoring_wechat_api_calls_total", "WeChat API calls by api and outcome (ok, api_error, request_error).", "api", "outcome")
+	apiDuration = metrics.NewHistogramVec("scoring_wechat_api_duration_seconds", "WeChat API call latency by api.", metrics.DefBuckets, "api")
+)
+
+// 微信接口返回的错误码，错误信息保持为 errmsg
+type apiError struct {
+	code int
+	msg  string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+// 记录一次微信接口调用的耗时及结果，用法：defer observeCall("jscode2session", time.Now(), &err)
+func observeCall(api string, start time.Time, err *error) {
+	apiDuration.With(api).Observe(time.Since(start).Seconds())
+	outcome := "ok"
+	var e *apiError
+	switch {
+	case *err == nil:
+	case errors.As(*err, &e):
+		outcome = "api_error"
+		slog.Warn("WeChat API returned an error", "api", api, "errcode", e.code, "errmsg", e.msg)
+	default:
+		outcome = "request_error"
+		slog.Warn("WeChat API request failed", "api", api, "err", *err)
+	}
+	apiCalls.With(api, outcome).Inc()
+}
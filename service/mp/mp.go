@@ -1,11 +1,15 @@
 package mp
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"scoringMP/config"
 )
@@ -19,11 +23,45 @@ type WxSessionData struct {
 	ErrMsg     string `json:"errmsg"`
 }
 
-func Code2Session(code string) (string, error) {
+// 微信接口请求的超时，需小于 server.shutdownTimeout，退出时不会被卡住的请求拖住
+const requestTimeout = 10 * time.Second
+
+// 调用微信接口的 HTTP 客户端
+var client = &http.Client{Timeout: requestTimeout}
+
+// 关闭与微信接口的空闲连接，用于退出时
+func CloseIdleConnections() {
+	client.CloseIdleConnections()
+}
+
+// 发送 GET 请求，ctx 取消（如客户端断开）时请求随之取消
+func get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, redactURLError(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, redactURLError(err)
+	}
+	return resp, nil
+}
+
+// 去掉请求错误中接口地址的查询参数，避免 secret、access_token 出现在日志和接口响应中
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL, _, _ = strings.Cut(urlErr.URL, "?")
+	}
+	return err
+}
+
+func Code2Session(ctx context.Context, code string) (openid string, err error) {
+	defer observeCall("jscode2session", time.Now(), &err)
 	// 微信接口地址
 	url := fmt.Sprintf("https://api.weixin.qq.com/sns/jscode2session?appid=%s&secret=%s&js_code=%s&grant_type=authorization_code", config.Config.AppId, config.Config.AppSecret, code)
 
-	resp, err := http.Get(url)
+	resp, err := get(ctx, url)
 	if err != nil {
 		return "", err
 	}
@@ -41,7 +79,7 @@ func Code2Session(code string) (string, error) {
 		return "", errors.New("parse JSON failed")
 	}
 	if wxData.ErrCode != 0 {
-		return "", errors.New(wxData.ErrMsg)
+		return "", &apiError{wxData.ErrCode, wxData.ErrMsg}
 	}
 	return wxData.OpenID, nil
 }
@@ -0,0 +1,50 @@
+package rating
+
+import "math"
+
+const (
+	// 初始分
+	Initial = 1500.0
+	// 每局最大变化幅度
+	K = 32.0
+)
+
+// 参与一局的玩家
+type Player struct {
+	Rating float64
+	// 本局净得分，用于决定名次
+	Score int
+}
+
+// 单个对手的期望胜率
+func expected(rating float64, opponent float64) float64 {
+	return 1 / (1 + math.Pow(10, (opponent-rating)/400))
+}
+
+// 计算多人对局后每位玩家的分数变化
+// 多人局拆成两两对局：名次高者记 1，同分记 0.5，名次低者记 0，
+// 再按对手数平均，使 3、4 人局与 2 人局的变化幅度一致
+func Update(players []Player) []float64 {
+	deltas := make([]float64, len(players))
+	if len(players) < 2 {
+		return deltas
+	}
+	scale := K / float64(len(players)-1)
+	for i, p := range players {
+		var sum float64
+		for j, o := range players {
+			if i == j {
+				continue
+			}
+			actual := 0.5
+			if p.Score > o.Score {
+				actual = 1
+			} else if p.Score < o.Score {
+				actual = 0
+			}
+			sum += actual - expected(p.Rating, o.Rating)
+		}
+		deltas[i] = scale * sum
+	}
+	return deltas
+}
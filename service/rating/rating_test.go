@@ -0,0 +1,44 @@
+package rating
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		players []Player
+		want    []float64
+	}{
+		{"single player", []Player{{Initial, 10}}, []float64{0}},
+		{"equal ratings, winner takes half of K", []Player{{Initial, 10}, {Initial, -10}}, []float64{16, -16}},
+		{"equal ratings, draw", []Player{{Initial, 0}, {Initial, 0}}, []float64{0, 0}},
+		// 4 人局按对手数平均，第一名相对三人全胜，变化幅度与 2 人局胜一场相同
+		{"four players", []Player{{Initial, 30}, {Initial, 10}, {Initial, -10}, {Initial, -30}}, []float64{16, 16.0 / 3, -16.0 / 3, -16}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Update(tt.players)
+			for i := range tt.want {
+				if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+					t.Errorf("got %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateFavouriteGainsLess(t *testing.T) {
+	favourite := Update([]Player{{1800, 10}, {1500, -10}})
+	underdog := Update([]Player{{1500, 10}, {1800, -10}})
+	if favourite[0] <= 0 || favourite[0] >= underdog[0] {
+		t.Errorf("favourite gained %v, underdog gained %v", favourite[0], underdog[0])
+	}
+	for _, deltas := range [][]float64{favourite, underdog} {
+		if math.Abs(deltas[0]+deltas[1]) > 1e-9 {
+			t.Errorf("deltas %v do not sum to zero", deltas)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package report
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+
+	"scoringMP/config"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+var reportTemplate = template.Must(template.New("report.html").Funcs(template.FuncMap{
+	"signed": func(n int) string {
+		if n > 0 {
+			return fmt.Sprintf("+%d", n)
+		}
+		return fmt.Sprint(n)
+	},
+	"inc": func(i int) int { return i + 1 },
+}).ParseFS(templates, "templates/report.html"))
+
+type Player struct {
+	Nickname string
+	Score    int
+}
+
+type Record struct {
+	Time     string
+	FromUser string
+	ToUser   string
+	Score    int
+}
+
+type Transfer struct {
+	FromUser string
+	ToUser   string
+	Points   int
+	// 已保存的结算才有付款状态
+	Status string
+}
+
+// 报告内容
+type Data struct {
+	RoomId     int
+	Owner      string
+	GameType   string
+	CreateData string
+	Opened     bool
+	Players    []Player
+	Records    []Record
+	// 每行一局，列与 Players 对应
+	Grid      [][]int
+	Transfers []Transfer
+	// 报告生成时间
+	Generated string
+}
+
+func Render(w io.Writer, data Data) error {
+	return reportTemplate.Execute(w, data)
+}
+
+var (
+	// 没有配置签名密钥，空密钥签名的链接任何人都能伪造
+	ErrNoSecret = errors.New("report secret is not configured")
+	ErrInvalid  = errors.New("link is invalid or expired")
+)
+
+// 签名密钥，未单独配置时使用小程序密钥
+func secret() ([]byte, error) {
+	if config.Config.ReportSecret != "" {
+		return []byte(config.Config.ReportSecret), nil
+	}
+	if config.Config.AppSecret != "" {
+		return []byte(config.Config.AppSecret), nil
+	}
+	return nil, ErrNoSecret
+}
+
+func signature(roomId int, exp int64) (string, error) {
+	key, err := secret()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "report:%d:%d", roomId, exp)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// 生成只读报告链接的查询参数，ttl 后失效
+func Sign(roomId int, ttl time.Duration) (string, error) {
+	exp := time.Now().Add(ttl).Unix()
+	sig, err := signature(roomId, exp)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("roomId=%d&exp=%d&sig=%s", roomId, exp, sig), nil
+}
+
+// 校验报告链接签名及有效期，未配置密钥时返回 ErrNoSecret，校验不通过时返回 ErrInvalid
+func Verify(roomId int, exp int64, sig string) error {
+	expected, err := signature(roomId, exp)
+	if err != nil {
+		return err
+	}
+	if time.Now().Unix() > exp || !hmac.Equal([]byte(sig), []byte(expected)) {
+		return ErrInvalid
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package settle
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// 超过该人数时不再精确求解，改用贪心
+const exactLimit = 16
+
+// 玩家净得分，正数为应收，负数为应付
+type Balance struct {
+	Openid string
+	Points int
+}
+
+// 一笔转账
+type Transfer struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Points int    `json:"points"`
+}
+
+// 计算清零所有余额所需的最少转账。
+// n 个非零余额最多需要 n-1 笔转账；若能拆成 k 组各自和为零的子集，
+// 只需 n-k 笔，因此用状态压缩求最多能拆出几组，再在每组内贪心配对
+func Minimize(balances []Balance) ([]Transfer, error) {
+	var nonzero []Balance
+	sum := 0
+	for _, b := range balances {
+		sum += b.Points
+		if b.Points != 0 {
+			nonzero = append(nonzero, b)
+		}
+	}
+	if sum != 0 {
+		return nil, errors.New("balances do not sum to zero")
+	}
+	sort.Slice(nonzero, func(i, j int) bool { return nonzero[i].Openid < nonzero[j].Openid })
+	transfers := []Transfer{}
+	if len(nonzero) > exactLimit {
+		return append(transfers, greedy(nonzero)...), nil
+	}
+	for _, group := range zeroSumGroups(nonzero) {
+		transfers = append(transfers, greedy(group)...)
+	}
+	return transfers, nil
+}
+
+// 把余额拆成尽可能多的和为零的分组
+func zeroSumGroups(balances []Balance) [][]Balance {
+	n := len(balances)
+	full := 1<<n - 1
+	sums := make([]int, full+1)
+	groups := make([]int, full+1)
+	for mask := 1; mask <= full; mask++ {
+		low := mask & -mask
+		i := bitIndex(low)
+		sums[mask] = sums[mask^low] + balances[i].Points
+		best := 0
+		for j := 0; j < n; j++ {
+			if mask&(1<<j) != 0 {
+				best = max(best, groups[mask^(1<<j)])
+			}
+		}
+		if sums[mask] == 0 {
+			best++
+		}
+		groups[mask] = best
+	}
+	// 沿最优路径逐个移除元素，每当剩余集合和为零即切出一组
+	var result [][]Balance
+	var current []Balance
+	for mask := full; mask != 0; {
+		bonus := 0
+		if sums[mask] == 0 {
+			bonus = 1
+		}
+		for j := 0; j < n; j++ {
+			bit := 1 << j
+			if mask&bit != 0 && groups[mask^bit]+bonus == groups[mask] {
+				current = append(current, balances[j])
+				mask ^= bit
+				break
+			}
+		}
+		if sums[mask] == 0 {
+			result = append(result, current)
+			current = nil
+		}
+	}
+	return result
+}
+
+func bitIndex(bit int) int {
+	i := 0
+	for bit > 1 {
+		bit >>= 1
+		i++
+	}
+	return i
+}
+
+// 每次让欠得最多的人付给应收最多的人
+func greedy(balances []Balance) []Transfer {
+	var debtors, creditors []Balance
+	for _, b := range balances {
+		if b.Points < 0 {
+			debtors = append(debtors, Balance{Openid: b.Openid, Points: -b.Points})
+		} else if b.Points > 0 {
+			creditors = append(creditors, b)
+		}
+	}
+	var transfers []Transfer
+	for len(debtors) > 0 && len(creditors) > 0 {
+		sort.SliceStable(debtors, func(i, j int) bool { return debtors[i].Points > debtors[j].Points })
+		sort.SliceStable(creditors, func(i, j int) bool { return creditors[i].Points > creditors[j].Points })
+		points := min(debtors[0].Points, creditors[0].Points)
+		transfers = append(transfers, Transfer{From: debtors[0].Openid, To: creditors[0].Openid, Points: points})
+		debtors[0].Points -= points
+		creditors[0].Points -= points
+		if debtors[0].Points == 0 {
+			debtors = debtors[1:]
+		}
+		if creditors[0].Points == 0 {
+			creditors = creditors[1:]
+		}
+	}
+	return transfers
+}
+
+// 金额取整方式及对应的最小单位
+var RoundUnits = map[string]float64{
+	"fen":  0.01,
+	"jiao": 0.1,
+	"yuan": 1,
+}
+
+// 按每分金额换算并四舍五入到指定单位
+func Amount(points int, rate float64, unit float64) float64 {
+	amount := math.Round(float64(points)*rate/unit) * unit
+	// 消除浮点误差，保留两位小数
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,98 @@
+package settle
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 执行转账后所有余额应清零
+func applyTransfers(t *testing.T, balances []Balance, transfers []Transfer) {
+	t.Helper()
+	left := map[string]int{}
+	for _, b := range balances {
+		left[b.Openid] += b.Points
+	}
+	for _, tr := range transfers {
+		if tr.Points <= 0 {
+			t.Errorf("transfer %+v has non-positive points", tr)
+		}
+		left[tr.From] += tr.Points
+		left[tr.To] -= tr.Points
+	}
+	for openid, points := range left {
+		if points != 0 {
+			t.Errorf("%s still has %d after transfers %+v", openid, points, transfers)
+		}
+	}
+}
+
+func TestMinimize(t *testing.T) {
+	tests := []struct {
+		name      string
+		balances  []Balance
+		transfers int
+	}{
+		{"empty", nil, 0},
+		{"all zero", []Balance{{"a", 0}, {"b", 0}}, 0},
+		{"two players", []Balance{{"a", 10}, {"b", -10}}, 1},
+		{"one creditor", []Balance{{"a", 30}, {"b", -10}, {"c", -20}}, 2},
+		// 可拆成 {a,b}、{c,d} 两组，只需 2 笔，贪心会得到 3 笔
+		{"two zero-sum groups", []Balance{{"a", 5}, {"b", -5}, {"c", 3}, {"d", -3}}, 2},
+		{"mixed groups", []Balance{{"a", 7}, {"b", -4}, {"c", -3}, {"d", 2}, {"e", -2}}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transfers, err := Minimize(tt.balances)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(transfers) != tt.transfers {
+				t.Errorf("got %d transfers %+v, want %d", len(transfers), transfers, tt.transfers)
+			}
+			applyTransfers(t, tt.balances, transfers)
+		})
+	}
+}
+
+func TestMinimizeNotZeroSum(t *testing.T) {
+	_, err := Minimize([]Balance{{"a", 10}, {"b", -9}})
+	if err == nil {
+		t.Fatal("expected error for balances not summing to zero")
+	}
+}
+
+func TestMinimizeGreedyAboveExactLimit(t *testing.T) {
+	var balances []Balance
+	for i := 0; i < exactLimit+4; i++ {
+		balances = append(balances, Balance{fmt.Sprintf("p%02d", i), i + 1}, Balance{fmt.Sprintf("q%02d", i), -(i + 1)})
+	}
+	transfers, err := Minimize(balances)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) > len(balances)-1 {
+		t.Errorf("got %d transfers, want at most %d", len(transfers), len(balances)-1)
+	}
+	applyTransfers(t, balances, transfers)
+}
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		points int
+		rate   float64
+		unit   string
+		want   float64
+	}{
+		{10, 0.5, "yuan", 5},
+		{3, 0.5, "yuan", 2},
+		{3, 0.5, "jiao", 1.5},
+		{7, 0.33, "fen", 2.31},
+		{-3, 0.5, "jiao", -1.5},
+	}
+	for _, tt := range tests {
+		got := Amount(tt.points, tt.rate, RoundUnits[tt.unit])
+		if got != tt.want {
+			t.Errorf("Amount(%d, %v, %s) = %v, want %v", tt.points, tt.rate, tt.unit, got, tt.want)
+		}
+	}
+}